@@ -0,0 +1,344 @@
+package ginger
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+/*
+JWTOpts are the options of the JWT authentication of the ApiOpts.Auth routes,
+the empty ones are read from the JWT_* environment variables.
+*/
+type JWTOpts struct {
+	// Secret verifies the HS256 tokens.
+	Secret string `json:"-"`
+	// PublicKey is the PEM public key verifying the RS256 or ES256 tokens.
+	PublicKey string `json:"-"`
+	// JWKSFile is the local JWKS file verifying the RS256 or ES256 tokens by their kid.
+	JWKSFile string `json:"jwks_file"`
+	// Issuer and Audience are required in the tokens when set.
+	Issuer   string `json:"issuer"`
+	Audience string `json:"audience"`
+	// Leeway is the clock skew allowed validating exp, nbf and iat.
+	Leeway time.Duration `json:"leeway"`
+}
+
+/*
+Claims are the claims of the authenticated token.
+*/
+type Claims struct {
+	Subject   string     `json:"sub"`
+	Issuer    string     `json:"iss"`
+	Audience  []string   `json:"aud"`
+	ExpiresAt time.Time  `json:"exp"`
+	IssuedAt  *time.Time `json:"iat"`
+	ID        string     `json:"jti"`
+
+	raw jwt.MapClaims
+}
+
+/*
+Get returns the raw value of the claim, e.g. a custom "role" claim.
+*/
+func (c *Claims) Get(name string) (any, bool) {
+	value, ok := c.raw[name]
+	return value, ok
+}
+
+/*
+Decode decodes all the claims into dest, e.g. a struct of the custom claims.
+*/
+func (c *Claims) Decode(dest any) error {
+	bytes, err := json.Marshal(c.raw)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bytes, dest)
+}
+
+/*
+SetJWT sets the JWT authentication options, the JWT_* environment variables are used without.
+*/
+func (e *engine) SetJWT(opts *JWTOpts) {
+	e.jwtOpts = opts
+}
+
+/*
+Claims returns the claims of the token authenticated by ApiOpts.Auth, nil without.
+*/
+func (c *Context[T]) Claims() *Claims {
+	claims, _ := c.GinCtx.Get(ctx_key_claims)
+	if claims, ok := claims.(*Claims); ok {
+		return claims
+	}
+	return nil
+}
+
+/*
+hasAuth reports whether any of the apis requires authentication.
+*/
+func (e *engine) hasAuth() bool {
+	for _, api := range e.ApiHandlers {
+		if api.Opts != nil && api.Opts.Auth {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+jwtVerifierOf returns the verifier of the tokens, loading the keys on first use.
+*/
+func (e *engine) jwtVerifierOf() (*jwtVerifier, error) {
+	e.jwtOnce.Do(func() {
+		e.jwtVerifier, e.jwtErr = newJWTVerifier(e.jwtOpts)
+	})
+	return e.jwtVerifier, e.jwtErr
+}
+
+/*
+authenticate returns the middleware rejecting the requests without a valid bearer token with 401,
+the claims of the token are kept for ctx.Claims.
+*/
+func (e *engine) authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		verifier, err := e.jwtVerifierOf()
+		if err != nil {
+			e.LogErr("jwt: ", err)
+			abortWithErr(c, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		token := bearerTokenOf(c.GetHeader(HEADER_AUTHORIZATION))
+		if token == "" {
+			c.Header(HEADER_WWW_AUTHENTICATE, "Bearer")
+			abortWithErr(c, http.StatusUnauthorized, "missing token")
+			return
+		}
+		claims, err := verifier.verify(token)
+		if err != nil {
+			c.Header(HEADER_WWW_AUTHENTICATE, `Bearer error="invalid_token"`)
+			abortWithErr(c, http.StatusUnauthorized, "invalid token")
+			return
+		}
+		c.Set(ctx_key_claims, claims)
+		c.Next()
+	}
+}
+
+type jwtVerifier struct {
+	secret    []byte
+	publicKey crypto.PublicKey
+	jwks      map[string]crypto.PublicKey
+	parser    *jwt.Parser
+}
+
+/*
+newJWTVerifier loads the keys of the options, filling the empty ones from the environment.
+*/
+func newJWTVerifier(opts *JWTOpts) (*jwtVerifier, error) {
+	resolved := new(JWTOpts)
+	if opts != nil {
+		*resolved = *opts
+	}
+	if resolved.Secret == "" {
+		resolved.Secret = Env("JWT_SECRET")
+	}
+	if resolved.PublicKey == "" {
+		resolved.PublicKey = strings.ReplaceAll(Env("JWT_PUBLIC_KEY"), `\n`, "\n")
+	}
+	if resolved.JWKSFile == "" {
+		resolved.JWKSFile = Env("JWT_JWKS_FILE")
+	}
+	if resolved.Issuer == "" {
+		resolved.Issuer = Env("JWT_ISSUER")
+	}
+	if resolved.Audience == "" {
+		resolved.Audience = Env("JWT_AUDIENCE")
+	}
+
+	v := &jwtVerifier{}
+	methods := make([]string, 0)
+	if resolved.Secret != "" {
+		v.secret = []byte(resolved.Secret)
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if resolved.PublicKey != "" {
+		key, err := parsePublicKey(resolved.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		v.publicKey = key
+	}
+	if resolved.JWKSFile != "" {
+		jwks, err := loadJWKS(resolved.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		v.jwks = jwks
+	}
+	if v.publicKey != nil || len(v.jwks) > 0 {
+		methods = append(methods, jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg())
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("no key, set JWT_SECRET, JWT_PUBLIC_KEY or JWT_JWKS_FILE")
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(resolved.Leeway),
+	}
+	if resolved.Issuer != "" {
+		options = append(options, jwt.WithIssuer(resolved.Issuer))
+	}
+	if resolved.Audience != "" {
+		options = append(options, jwt.WithAudience(resolved.Audience))
+	}
+	v.parser = jwt.NewParser(options...)
+	return v, nil
+}
+
+/*
+verify validates the signature and the claims of the token.
+*/
+func (v *jwtVerifier) verify(token string) (*Claims, error) {
+	raw := jwt.MapClaims{}
+	if _, err := v.parser.ParseWithClaims(token, raw, v.keyOf); err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{raw: raw}
+	claims.Subject, _ = raw.GetSubject()
+	claims.Issuer, _ = raw.GetIssuer()
+	claims.Audience, _ = raw.GetAudience()
+	if exp, _ := raw.GetExpirationTime(); exp != nil {
+		claims.ExpiresAt = exp.Time
+	}
+	if iat, _ := raw.GetIssuedAt(); iat != nil {
+		claims.IssuedAt = &iat.Time
+	}
+	claims.ID, _ = raw["jti"].(string)
+	return claims, nil
+}
+
+/*
+keyOf returns the key verifying the token, the secret for HS256,
+otherwise the JWKS key of the kid or the public key, of the type of the algorithm.
+*/
+func (v *jwtVerifier) keyOf(token *jwt.Token) (any, error) {
+	if token.Method == jwt.SigningMethodHS256 && len(v.secret) > 0 {
+		return v.secret, nil
+	}
+
+	var key crypto.PublicKey
+	if kid, ok := token.Header["kid"].(string); ok {
+		key = v.jwks[kid]
+	}
+	if key == nil && v.publicKey != nil {
+		key = v.publicKey
+	} else if key == nil && len(v.jwks) == 1 {
+		for _, k := range v.jwks {
+			key = k
+		}
+	}
+
+	switch token.Method {
+	case jwt.SigningMethodRS256:
+		if rsaKey, ok := key.(*rsa.PublicKey); ok {
+			return rsaKey, nil
+		}
+	case jwt.SigningMethodES256:
+		if ecKey, ok := key.(*ecdsa.PublicKey); ok && ecKey.Curve == elliptic.P256() {
+			return ecKey, nil
+		}
+	}
+	return nil, errors.New("no key for the token")
+}
+
+/*
+parsePublicKey parses the PEM RSA or EC public key, or the public key of a certificate.
+*/
+func parsePublicKey(data string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("jwt public key is not PEM")
+	}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+}
+
+/*
+loadJWKS loads the RSA and P-256 EC keys of the JWKS file by kid.
+*/
+func loadJWKS(file string) (map[string]crypto.PublicKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	jwks := struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("jwks %s: %w", file, err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range jwks.Keys {
+		switch {
+		case k.Kty == "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil {
+				return nil, fmt.Errorf("jwks %s: invalid RSA key %s", file, k.Kid)
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				return nil, fmt.Errorf("jwks %s: invalid EC key %s", file, k.Kid)
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("jwks %s: no RSA or P-256 key", file)
+	}
+	return keys, nil
+}
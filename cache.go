@@ -0,0 +1,448 @@
+package ginger
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/gin-gonic/gin"
+	"github.com/gomodule/redigo/redis"
+)
+
+/*
+CacheStore keeps the cached values, e.g. NewMemoryCacheStore, NewRedisCacheStore or NewMemcachedCacheStore.
+A zero ttl keeps the value until it is evicted.
+*/
+type CacheStore interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+/*
+SetCacheStore sets the default store of the caches, overridden by CacheOpt.Store.
+The default store is in memory, per process, limited to DEFAULT_CACHE_MAX_BYTES.
+*/
+func (e *engine) SetCacheStore(store CacheStore) {
+	e.cacheStore = store
+}
+
+/*
+ResponseCache returns the cache of the api responses of the engine, e.g. to invalidate them.
+*/
+func (e *engine) ResponseCache() *ResponseCache {
+	return &ResponseCache{engine: e}
+}
+
+/*
+cacheStoreOf returns the store of the cache option, creating the memory store on first use.
+*/
+func (e *engine) cacheStoreOf(opt *CacheOpt) CacheStore {
+	if opt != nil && opt.Store != nil {
+		return opt.Store
+	}
+	e.cacheOnce.Do(func() {
+		if e.cacheStore == nil {
+			e.cacheStore = NewMemoryCacheStore(DEFAULT_CACHE_MAX_BYTES)
+		}
+	})
+	return e.cacheStore
+}
+
+/*
+ResponseCache is the cache of the api responses, e.g. taken by the helpers of the write handlers.
+*/
+type ResponseCache struct {
+	engine *engine
+}
+
+/*
+InvalidateTag invalidates the responses cached with any of the tags, e.g. from the write handlers:
+
+	ctx.Engine.ResponseCache().InvalidateTag("users")
+*/
+func (s *ResponseCache) InvalidateTag(tags ...string) error {
+	ctx := context.Background()
+	version := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+	errs := make([]error, 0)
+	for _, store := range s.stores() {
+		for _, tag := range tags {
+			if err := store.Set(ctx, cacheTagKey(tag), version, 0); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+/*
+stores returns the default store and the stores of the routes.
+*/
+func (s *ResponseCache) stores() []CacheStore {
+	stores := []CacheStore{s.engine.cacheStoreOf(nil)}
+	for _, api := range s.engine.ApiHandlers {
+		if api.Opts == nil || api.Opts.Cache == nil || api.Opts.Cache.Store == nil {
+			continue
+		}
+		store := api.Opts.Cache.Store
+		duplicated := false
+		for i := range stores {
+			if stores[i] == store {
+				duplicated = true
+				break
+			}
+		}
+		if !duplicated {
+			stores = append(stores, store)
+		}
+	}
+	return stores
+}
+
+func cacheTagKey(tag string) string {
+	return "ginger_cache:tag:" + tag
+}
+
+/*
+tagVersions returns the current versions of the tags.
+A tag without version, never invalidated or evicted, is given one,
+so that the responses cached before are not served again.
+*/
+func tagVersions(ctx context.Context, store CacheStore, tags []string) ([]string, error) {
+	versions := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		value, ok, err := store.Get(ctx, cacheTagKey(tag))
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			value = []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+			if err := store.Set(ctx, cacheTagKey(tag), value, 0); err != nil {
+				return nil, err
+			}
+		}
+		versions = append(versions, string(value))
+	}
+	return versions, nil
+}
+
+/*
+cachedPage is a response kept in the cache.
+*/
+type cachedPage struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+/*
+cacheWriter writes the response through and keeps a copy of the body.
+*/
+type cacheWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *cacheWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *cacheWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+/*
+pageKey returns the cache key of the request, varying by the url, the headers of the option,
+the user of an authenticated request, by the claims or the Authorization header, and the versions of its tags.
+*/
+func pageKey(c *gin.Context, store CacheStore, opt *CacheOpt) (string, error) {
+	versions, err := tagVersions(c, store, opt.Tags)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write([]byte(c.Request.Method + " " + c.Request.URL.RequestURI()))
+	for _, header := range opt.VaryHeaders {
+		h.Write([]byte("\n" + header + ": " + c.GetHeader(header)))
+	}
+	// the responses of authenticated requests are never shared between users
+	if claims, ok := c.Get(ctx_key_claims); ok {
+		h.Write([]byte("\nsub: " + claims.(*Claims).Subject))
+	} else if auth := c.GetHeader(HEADER_AUTHORIZATION); auth != "" {
+		h.Write([]byte("\n" + HEADER_AUTHORIZATION + ": " + auth))
+	}
+	for i, tag := range opt.Tags {
+		h.Write([]byte("\n" + tag + "@" + versions[i]))
+	}
+	return "ginger_cache:page:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+/*
+cachePage wraps the handler with the response cache and records the hits and misses.
+The handler is only invoked on a miss, and only its successful envelopes are cached.
+A store failure serves the request without the cache.
+*/
+func (e *engine) cachePage(method, route string, opt *CacheOpt, handler gin.HandlerFunc) gin.HandlerFunc {
+	vary := strings.Join(opt.VaryHeaders, ", ")
+	varyAuth := !slices.ContainsFunc(opt.VaryHeaders, func(header string) bool {
+		return strings.EqualFold(header, HEADER_AUTHORIZATION)
+	})
+	return func(c *gin.Context) {
+		if varyAuth && c.GetHeader(HEADER_AUTHORIZATION) != "" {
+			c.Header("Vary", strings.TrimPrefix(vary+", "+HEADER_AUTHORIZATION, ", "))
+		} else if vary != "" {
+			c.Header("Vary", vary)
+		}
+
+		store := e.cacheStoreOf(opt)
+		key, err := pageKey(c, store, opt)
+		if err != nil {
+			e.LogErr("cache ", method, " ", route, ": ", err)
+			handler(c)
+			return
+		}
+
+		data, ok, err := store.Get(c, key)
+		if err != nil {
+			e.LogErr("cache ", method, " ", route, ": ", err)
+		}
+		page := new(cachedPage)
+		if ok && json.Unmarshal(data, page) == nil {
+			e.metrics.cacheHit(method, route)
+			for k, values := range page.Header {
+				for _, v := range values {
+					c.Writer.Header().Add(k, v)
+				}
+			}
+			lastModified, _ := http.ParseTime(page.Header.Get("Last-Modified"))
+			if writeNotModified(c, page.Header.Get("ETag"), lastModified) {
+				return
+			}
+			c.Data(page.Status, page.Header.Get("Content-Type"), page.Body)
+			return
+		}
+		e.metrics.cacheMiss(method, route)
+
+		writer := &cacheWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		handler(c)
+		c.Writer = writer.ResponseWriter
+
+		if !c.GetBool(ctx_key_cacheable) || writer.Status() != http.StatusOK {
+			return
+		}
+		header := http.Header{}
+		for _, k := range []string{"Content-Type", "ETag", "Last-Modified"} {
+			if v := writer.Header().Get(k); v != "" {
+				header.Set(k, v)
+			}
+		}
+		data, err = json.Marshal(&cachedPage{
+			Status: writer.Status(),
+			Header: header,
+			Body:   writer.body.Bytes(),
+		})
+		if err == nil {
+			err = store.Set(c, key, data, opt.Duration)
+		}
+		if err != nil {
+			e.LogErr("cache ", method, " ", route, ": ", err)
+		}
+	}
+}
+
+/*
+NewMemoryCacheStore returns an in-process cache store evicting the least recently used values
+over maxBytes of keys and values, zero means no limit.
+*/
+func NewMemoryCacheStore(maxBytes int) CacheStore {
+	return &memoryCacheStore{
+		maxBytes: maxBytes,
+		items:    make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+type memoryCacheStore struct {
+	mu       sync.Mutex
+	maxBytes int
+	size     int
+	items    map[string]*list.Element
+	lru      *list.List
+}
+
+type memoryCacheItem struct {
+	key      string
+	value    []byte
+	expireAt time.Time
+}
+
+func (s *memoryCacheStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elem, ok := s.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	item := elem.Value.(*memoryCacheItem)
+	if !item.expireAt.IsZero() && time.Now().After(item.expireAt) {
+		s.remove(elem)
+		return nil, false, nil
+	}
+	s.lru.MoveToFront(elem)
+	return item.value, true, nil
+}
+
+func (s *memoryCacheStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elem, ok := s.items[key]; ok {
+		s.remove(elem)
+	}
+	item := &memoryCacheItem{key: key, value: value}
+	if ttl > 0 {
+		item.expireAt = time.Now().Add(ttl)
+	}
+	s.items[key] = s.lru.PushFront(item)
+	s.size += len(key) + len(value)
+	for s.maxBytes > 0 && s.size > s.maxBytes && s.lru.Len() > 0 {
+		s.remove(s.lru.Back())
+	}
+	return nil
+}
+
+func (s *memoryCacheStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elem, ok := s.items[key]; ok {
+		s.remove(elem)
+	}
+	return nil
+}
+
+func (s *memoryCacheStore) remove(elem *list.Element) {
+	item := s.lru.Remove(elem).(*memoryCacheItem)
+	delete(s.items, item.key)
+	s.size -= len(item.key) + len(item.value)
+}
+
+/*
+NewRedisCacheStore returns a cache store on a Redis-compatible server,
+shared by all the replicas connected to it.
+*/
+func NewRedisCacheStore(opts *RedisOpts) (CacheStore, error) {
+	pool, err := newRedisPool(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &redisCacheStore{pool: pool}, nil
+}
+
+type redisCacheStore struct {
+	pool *redis.Pool
+}
+
+func (s *redisCacheStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.Close()
+	value, err := redis.Bytes(redis.DoContext(conn, ctx, "GET", key))
+	if errors.Is(err, redis.ErrNil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (s *redisCacheStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if ttl > 0 {
+		_, err = redis.DoContext(conn, ctx, "SET", key, value, "PX", ttl.Milliseconds())
+	} else {
+		_, err = redis.DoContext(conn, ctx, "SET", key, value)
+	}
+	return err
+}
+
+func (s *redisCacheStore) Delete(ctx context.Context, key string) error {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = redis.DoContext(conn, ctx, "DEL", key)
+	return err
+}
+
+/*
+NewMemcachedCacheStore returns a cache store on the memcached servers, e.g. localhost:11211.
+*/
+func NewMemcachedCacheStore(servers ...string) CacheStore {
+	return &memcachedCacheStore{client: memcache.New(servers...)}
+}
+
+type memcachedCacheStore struct {
+	client *memcache.Client
+}
+
+/*
+key hashes the long keys, memcached limits them to 250 bytes.
+*/
+func (s *memcachedCacheStore) key(key string) string {
+	if len(key) <= 200 && !strings.ContainsAny(key, " \t\r\n") {
+		return key
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "ginger_cache:" + hex.EncodeToString(sum[:])
+}
+
+func (s *memcachedCacheStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	item, err := s.client.Get(s.key(key))
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return item.Value, true, nil
+}
+
+func (s *memcachedCacheStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	// memcached reads an expiration over 30 days as a unix time
+	expiration := int32(0)
+	if ttl > 0 && ttl <= 30*24*time.Hour {
+		expiration = int32(max(ttl/time.Second, 1))
+	} else if ttl > 0 {
+		expiration = int32(time.Now().Add(ttl).Unix())
+	}
+	return s.client.Set(&memcache.Item{Key: s.key(key), Value: value, Expiration: expiration})
+}
+
+func (s *memcachedCacheStore) Delete(ctx context.Context, key string) error {
+	err := s.client.Delete(s.key(key))
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+package ginger
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+SetLoadShedding sets the max number of api requests served at the same time,
+the requests over it are rejected with 503 until the load goes down.
+Zero means no limit.
+*/
+func (e *engine) SetLoadShedding(maxInFlight int) {
+	e.Configs.MaxInFlight = maxInFlight
+}
+
+/*
+loadShedding returns the middleware rejecting the request when the engine is overloaded.
+*/
+func (e *engine) loadShedding(method, route string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		n := e.inFlight.Add(1)
+		defer e.inFlight.Add(-1)
+		if n > int64(e.Configs.MaxInFlight) {
+			e.metrics.requestShed(method, route, "overload")
+			c.Header(HEADER_RETRY_AFTER, "1")
+			abortWithErr(c, http.StatusServiceUnavailable, "server overloaded")
+			return
+		}
+		c.Next()
+	}
+}
+
+/*
+concurrencyLimits returns the middlewares of the concurrency limits of the route,
+the group ones matching the route followed by ApiOpts.MaxConcurrent.
+*/
+func (e *engine) concurrencyLimits(method, route string, opts *ApiOpts) []gin.HandlerFunc {
+	handlers := make([]gin.HandlerFunc, 0)
+	for _, group := range e.ConcurrencyLimits {
+		if group.Max <= 0 || !pathMatches(group.matchRegexps, route) {
+			continue
+		}
+		handlers = append(handlers, e.concurrencyLimit(method, route, group.slots))
+	}
+	if opts != nil && opts.MaxConcurrent > 0 {
+		handlers = append(handlers, e.concurrencyLimit(method, route, make(chan struct{}, opts.MaxConcurrent)))
+	}
+	return handlers
+}
+
+/*
+concurrencyLimit returns the middleware taking one of the slots for the rest of the request,
+rejecting the request when all of them are taken.
+*/
+func (e *engine) concurrencyLimit(method, route string, slots chan struct{}) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			c.Next()
+		default:
+			e.metrics.requestShed(method, route, "concurrency")
+			c.Header(HEADER_RETRY_AFTER, "1")
+			abortWithErr(c, http.StatusServiceUnavailable, "too many concurrent requests")
+		}
+	}
+}
+
+/*
+pathMatches reports whether the route matches any of the path regexps, or there is none.
+*/
+func pathMatches(regexps []*regexp.Regexp, route string) bool {
+	if len(regexps) == 0 {
+		return true
+	}
+	for _, re := range regexps {
+		if re.MatchString(route) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package ginger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/METADIV-GO/gorm"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+OKWithETag returns a successful response with the version of the resource as ETag,
+responding 304 Not Modified when the client already has it.
+*/
+func (c *Context[T]) OKWithETag(data any, etag string, page ...*gorm.Pagination) {
+	if c.hasResp {
+		c.LogErr("double response")
+		return
+	}
+	c.OK(data, page...)
+	c.etag = quoteETag(etag)
+}
+
+/*
+OKWithLastModified returns a successful response with the modification time of the resource,
+responding 304 Not Modified when the client has it since.
+*/
+func (c *Context[T]) OKWithLastModified(data any, modifiedAt time.Time, page ...*gorm.Pagination) {
+	if c.hasResp {
+		c.LogErr("double response")
+		return
+	}
+	c.OK(data, page...)
+	c.lastModified = modifiedAt
+}
+
+/*
+notModified sets the validators of a successful GET response and writes 304 Not Modified
+when the request preconditions match them. Without an explicit ETag,
+a weak one is generated from the envelope.
+*/
+func (c *Context[T]) notModified() bool {
+	method := c.GinCtx.Request.Method
+	if (method != http.MethodGet && method != http.MethodHead) || c.status != http.StatusOK ||
+		c.Response == nil || !c.Response.Success {
+		return false
+	}
+
+	etag := c.etag
+	if etag == "" {
+		etag = weakETag(c.Response)
+	}
+	return writeNotModified(c.GinCtx, etag, c.lastModified)
+}
+
+/*
+writeNotModified sets the ETag and Last-Modified headers
+and writes 304 Not Modified when the request preconditions match them.
+If-None-Match takes precedence over If-Modified-Since.
+*/
+func writeNotModified(c *gin.Context, etag string, lastModified time.Time) bool {
+	if etag != "" {
+		c.Header("ETag", etag)
+	}
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	match := false
+	if inm := c.GetHeader("If-None-Match"); inm != "" {
+		match = etag != "" && etagMatches(inm, etag)
+	} else if ims := c.GetHeader("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(ims)
+		match = err == nil && !lastModified.Truncate(time.Second).After(since)
+	}
+	if !match {
+		return false
+	}
+	c.Status(http.StatusNotModified)
+	c.Writer.WriteHeaderNow()
+	return true
+}
+
+/*
+weakETag returns the weak ETag of the envelope,
+the fields that change on every response are left out.
+*/
+func weakETag(resp *Response) string {
+	stable := *resp
+	stable.Time = ""
+	stable.TraceId = ""
+	stable.Duration = 0
+	bytes, err := json.Marshal(&stable)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(bytes)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+/*
+etagMatches compares the If-None-Match header with the ETag with the weak comparison.
+*/
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func quoteETag(etag string) string {
+	if strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, `W/"`) {
+		return etag
+	}
+	return `"` + etag + `"`
+}
@@ -0,0 +1,378 @@
+package ginger
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	_mysql "github.com/go-sql-driver/mysql"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+/*
+DBOpts is the connection options of a database.
+The zero values are read from the environment variables, e.g. GORM_DSN for
+the database, GORM_MEM_DSN for the memory database and GORM_<NAME>_DSN for
+the databases registered with RegisterDB.
+*/
+type DBOpts struct {
+	// DSN overrides the one built from GORM_HOST, GORM_PORT, etc.
+	// The TLS parameters must be part of it when it is set.
+	DSN             string        `json:"-"`
+	MaxOpenConns    int           `json:"max_open_conns"`
+	MaxIdleConns    int           `json:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `json:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `json:"conn_max_idle_time"`
+	TLS             *TLSOpt       `json:"tls"`
+	// LogLevel is one of the DB_LOG_LEVEL_* constants.
+	LogLevel string `json:"log_level"`
+	// Conn is an already constructed connection, used as is.
+	Conn *gorm.DB `json:"-"`
+
+	// Replicas are the DSNs of the read replicas, read with DBRead().
+	Replicas []string `json:"-"`
+	// MaxReplicaLag takes a replica out of the rotation when it lags behind more, zero means no lag check.
+	MaxReplicaLag time.Duration `json:"max_replica_lag"`
+	// ReplicaOnGet makes DB() of the GET handlers read from the replicas.
+	ReplicaOnGet bool `json:"replica_on_get"`
+}
+
+/*
+TLSOpt is the TLS options of a database connection.
+Mode is the MySQL tls parameter (true, skip-verify, preferred) or
+the PostgreSQL sslmode (require, verify-ca, verify-full).
+CA, Cert and Key are file paths.
+*/
+type TLSOpt struct {
+	Mode       string `json:"mode"`
+	CA         string `json:"ca"`
+	Cert       string `json:"cert"`
+	Key        string `json:"key"`
+	ServerName string `json:"server_name"`
+}
+
+/*
+connect opens the database of the type with the options resolved from the
+environment variables, retrying with an exponential backoff until the
+connect retry period passes.
+*/
+func (e *engine) connect(name, dbType string, opts *DBOpts) (*gorm.DB, *DBOpts, error) {
+	opts, err := resolveDBOpts(name, opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connect %s: %w", name, err)
+	}
+	if opts.Conn != nil {
+		return opts.Conn, opts, nil
+	}
+	if dbType == DB_TYPE_NONE {
+		return nil, opts, nil
+	}
+	db, err := e.retryOpen(name, dbType, opts)
+	return db, opts, err
+}
+
+func (e *engine) retryOpen(name, dbType string, opts *DBOpts) (*gorm.DB, error) {
+	deadline := time.Now().Add(e.Configs.ConnectRetry)
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		db, err := open(name, dbType, opts)
+		if err == nil {
+			return db, nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return nil, fmt.Errorf("connect %s (%s): %w", name, dbType, err)
+		}
+		e.LogErr("connect ", name, " (", dbType, ") attempt ", attempt, " failed, retrying in ", backoff, ": ", err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, 10*time.Second)
+	}
+}
+
+func open(name, dbType string, opts *DBOpts) (*gorm.DB, error) {
+	dialector, err := dialectorOf(name, dbType, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{
+		Logger: logger.Default.LogMode(logLevelOf(opts.LogLevel)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(opts.MaxOpenConns)
+	}
+	if opts.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+	if opts.ConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	}
+	if opts.ConnMaxIdleTime > 0 {
+		sqlDB.SetConnMaxIdleTime(opts.ConnMaxIdleTime)
+	}
+	return db, nil
+}
+
+func dialectorOf(name, dbType string, opts *DBOpts) (gorm.Dialector, error) {
+	switch dbType {
+	case DB_TYPE_MYSQL:
+		dsn := opts.DSN
+		if dsn == "" {
+			dsn = fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
+				dbEnv(name, "USERNAME"), dbEnv(name, "PASSWORD"), dbEnv(name, "HOST"), dbEnv(name, "PORT"), dbEnv(name, "DATABASE"))
+		}
+		if opts.TLS != nil {
+			param, err := mysqlTLS(name, opts.TLS)
+			if err != nil {
+				return nil, err
+			}
+			if opts.DSN == "" {
+				dsn += "&tls=" + url.QueryEscape(param)
+			}
+		}
+		return mysql.Open(dsn), nil
+	case DB_TYPE_PGSQL:
+		dsn := opts.DSN
+		if dsn == "" {
+			dsn = fmt.Sprintf("host=%s port=%s user=%s dbname=%s password=%s",
+				dbEnv(name, "HOST"), dbEnv(name, "PORT"), dbEnv(name, "USERNAME"), dbEnv(name, "DATABASE"), dbEnv(name, "PASSWORD"))
+			dsn += postgresTLS(opts.TLS)
+		}
+		return postgres.Open(dsn), nil
+	case DB_TYPE_SQLITE:
+		dsn := opts.DSN
+		if dsn == "" {
+			dsn = dbEnv(name, "SQLITE_PATH")
+		}
+		if dsn == "" {
+			return nil, errors.New(dbEnvPrefix(name) + "SQLITE_PATH is empty")
+		}
+		return sqlite.Open(dsn), nil
+	case DB_TYPE_MEM:
+		dsn := opts.DSN
+		// every database has its own memory, shared by the connections of its pool
+		if dsn == "" {
+			dsn = "file:ginger_" + name + "?mode=memory&cache=shared&busy_timeout=5000"
+		}
+		return sqlite.Open(dsn), nil
+	}
+	return nil, fmt.Errorf("unknown database type %q", dbType)
+}
+
+/*
+mysqlTLS returns the value of the MySQL tls parameter.
+Custom certificates are registered as tls=ginger-<name>.
+*/
+func mysqlTLS(name string, opt *TLSOpt) (string, error) {
+	if opt.CA == "" && opt.Cert == "" {
+		if opt.Mode == "" {
+			return "true", nil
+		}
+		return opt.Mode, nil
+	}
+
+	config := &tls.Config{
+		ServerName:         opt.ServerName,
+		InsecureSkipVerify: opt.Mode == "skip-verify",
+	}
+	if opt.CA != "" {
+		pem, err := os.ReadFile(opt.CA)
+		if err != nil {
+			return "", fmt.Errorf("read tls ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return "", errors.New("invalid tls ca " + opt.CA)
+		}
+		config.RootCAs = pool
+	}
+	if opt.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(opt.Cert, opt.Key)
+		if err != nil {
+			return "", fmt.Errorf("load tls certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	key := "ginger-" + name
+	if err := _mysql.RegisterTLSConfig(key, config); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+/*
+postgresTLS returns the ssl parameters of the PostgreSQL DSN.
+*/
+func postgresTLS(opt *TLSOpt) string {
+	if opt == nil {
+		return " sslmode=disable"
+	}
+	mode := opt.Mode
+	if mode == "" {
+		mode = "require"
+	}
+	params := " sslmode=" + mode
+	if opt.CA != "" {
+		params += " sslrootcert=" + opt.CA
+	}
+	if opt.Cert != "" {
+		params += " sslcert=" + opt.Cert
+	}
+	if opt.Key != "" {
+		params += " sslkey=" + opt.Key
+	}
+	return params
+}
+
+/*
+resolveDBOpts fills the empty options from the environment variables.
+*/
+func resolveDBOpts(name string, opts *DBOpts) (*DBOpts, error) {
+	output := new(DBOpts)
+	if opts != nil {
+		*output = *opts
+	}
+	prefix := dbEnvPrefix(name)
+
+	if output.DSN == "" {
+		output.DSN = Env(prefix + "DSN")
+	}
+
+	var err error
+	if output.MaxOpenConns == 0 {
+		if output.MaxOpenConns, err = envInt(prefix + "MAX_OPEN_CONNS"); err != nil {
+			return nil, err
+		}
+	}
+	if output.MaxIdleConns == 0 {
+		if output.MaxIdleConns, err = envInt(prefix + "MAX_IDLE_CONNS"); err != nil {
+			return nil, err
+		}
+	}
+	if output.ConnMaxLifetime == 0 {
+		if output.ConnMaxLifetime, err = envDuration(prefix + "CONN_MAX_LIFETIME"); err != nil {
+			return nil, err
+		}
+	}
+	if output.ConnMaxIdleTime == 0 {
+		if output.ConnMaxIdleTime, err = envDuration(prefix + "CONN_MAX_IDLE_TIME"); err != nil {
+			return nil, err
+		}
+	}
+
+	if output.TLS == nil && Env(prefix+"TLS_MODE") != "" {
+		output.TLS = &TLSOpt{
+			Mode:       Env(prefix + "TLS_MODE"),
+			CA:         Env(prefix + "TLS_CA"),
+			Cert:       Env(prefix + "TLS_CERT"),
+			Key:        Env(prefix + "TLS_KEY"),
+			ServerName: Env(prefix + "TLS_SERVER_NAME"),
+		}
+	}
+
+	if len(output.Replicas) == 0 && Env(prefix+"REPLICAS") != "" {
+		output.Replicas = strings.Split(Env(prefix+"REPLICAS"), ",")
+	}
+	if output.MaxReplicaLag == 0 {
+		if output.MaxReplicaLag, err = envDuration(prefix + "MAX_REPLICA_LAG"); err != nil {
+			return nil, err
+		}
+	}
+	if !output.ReplicaOnGet {
+		output.ReplicaOnGet = Env(prefix+"REPLICA_ON_GET") == "true"
+	}
+
+	if output.LogLevel == "" {
+		output.LogLevel = Env(prefix + "LOG_LEVEL")
+	}
+	if output.LogLevel == "" {
+		if Env("GORM_SILENT") == "true" || Env("GIN_MODE") == "release" {
+			output.LogLevel = DB_LOG_LEVEL_SILENT
+		} else {
+			output.LogLevel = DB_LOG_LEVEL_WARN
+		}
+	}
+	switch output.LogLevel {
+	case DB_LOG_LEVEL_SILENT, DB_LOG_LEVEL_ERROR, DB_LOG_LEVEL_WARN, DB_LOG_LEVEL_INFO:
+	default:
+		return nil, fmt.Errorf("unknown log level %q", output.LogLevel)
+	}
+
+	return output, nil
+}
+
+/*
+dbEnvPrefix returns the environment variable prefix of the database,
+GORM_ for the database and GORM_<NAME>_ for the others.
+*/
+func dbEnvPrefix(name string) string {
+	if name == "db" {
+		return "GORM_"
+	}
+	return "GORM_" + strings.ToUpper(name) + "_"
+}
+
+/*
+dbEnv returns the environment variable of the database,
+falling back to the GORM_* one, e.g. GORM_REPORTING_HOST then GORM_HOST.
+*/
+func dbEnv(name, key string) string {
+	if value := Env(dbEnvPrefix(name) + key); value != "" {
+		return value
+	}
+	return Env("GORM_" + key)
+}
+
+func logLevelOf(level string) logger.LogLevel {
+	switch level {
+	case DB_LOG_LEVEL_SILENT:
+		return logger.Silent
+	case DB_LOG_LEVEL_ERROR:
+		return logger.Error
+	case DB_LOG_LEVEL_INFO:
+		return logger.Info
+	}
+	return logger.Warn
+}
+
+func envInt(key string) (int, error) {
+	value := Env(key)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", key, value)
+	}
+	return n, nil
+}
+
+func envDuration(key string) (time.Duration, error) {
+	value := Env(key)
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", key, value)
+	}
+	return d, nil
+}
@@ -1,12 +1,75 @@
 package ginger
 
+import "time"
+
+const (
+	HEADER_AUTHORIZATION          = "Authorization"
+	HEADER_X_LOCALE               = "X-Locale"
+	HEADER_RETRY_AFTER            = "Retry-After"
+	HEADER_X_RATE_LIMIT_LIMIT     = "X-RateLimit-Limit"
+	HEADER_X_RATE_LIMIT_REMAINING = "X-RateLimit-Remaining"
+	HEADER_X_RATE_LIMIT_RESET     = "X-RateLimit-Reset"
+	HEADER_WWW_AUTHENTICATE       = "WWW-Authenticate"
+)
+
+const (
+	DB_TYPE_MYSQL  = "mysql"
+	DB_TYPE_PGSQL  = "postgres"
+	DB_TYPE_SQLITE = "sqlite"
+	DB_TYPE_MEM    = "memory"
+	DB_TYPE_NONE   = "none"
+)
+
+const (
+	DB_LOG_LEVEL_SILENT = "silent"
+	DB_LOG_LEVEL_ERROR  = "error"
+	DB_LOG_LEVEL_WARN   = "warn"
+	DB_LOG_LEVEL_INFO   = "info"
+)
+
+var dbTypes = []string{DB_TYPE_MYSQL, DB_TYPE_PGSQL, DB_TYPE_SQLITE, DB_TYPE_MEM, DB_TYPE_NONE}
+
+const (
+	HEALTH_STATUS_UP        = "up"
+	HEALTH_STATUS_DOWN      = "down"
+	HEALTH_STATUS_READY     = "ready"
+	HEALTH_STATUS_NOT_READY = "not_ready"
+)
+
+const (
+	DEFAULT_HEALTH_CHECK_TIMEOUT = 3 * time.Second
+	DEFAULT_SHUTDOWN_DELAY       = 5 * time.Second
+	DEFAULT_SHUTDOWN_TIMEOUT     = 10 * time.Second
+	DEFAULT_MIGRATE_LOCK_TIMEOUT = 5 * time.Minute
+	DEFAULT_CONNECT_RETRY        = 30 * time.Second
+	DEFAULT_CURSOR_LIMIT         = 20
+	DEFAULT_MAX_PAGE_SIZE        = 100
+	DEFAULT_CACHE_MAX_BYTES      = 64 << 20
+	DEFAULT_REPLICA_CHECK        = 5 * time.Second
+)
+
+const (
+	RESOURCE_ACTION_LIST   = "list"
+	RESOURCE_ACTION_GET    = "get"
+	RESOURCE_ACTION_CREATE = "create"
+	RESOURCE_ACTION_UPDATE = "update"
+	RESOURCE_ACTION_DELETE = "delete"
+)
+
 const (
-	HEADER_AUTHORIZATION = "Authorization"
-	HEADER_X_LOCALE      = "X-Locale"
+	FILTER_OP_EQ   = "eq"
+	FILTER_OP_NEQ  = "neq"
+	FILTER_OP_GT   = "gt"
+	FILTER_OP_GTE  = "gte"
+	FILTER_OP_LT   = "lt"
+	FILTER_OP_LTE  = "lte"
+	FILTER_OP_LIKE = "like"
+	FILTER_OP_IN   = "in"
+	FILTER_OP_NULL = "null"
 )
 
 const (
-	DB_TYPE_MYSQL = "mysql"
-	DB_TYPE_PGSQL = "postgres"
-	DB_TYPE_MEM   = "memory"
+	ctx_key_cacheable = "ginger_cacheable"
+	ctx_key_claims    = "ginger_claims"
+	ctx_key_trace_id  = "ginger_trace_id"
 )
@@ -1,8 +1,12 @@
 package ginger
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"regexp"
+	"slices"
 	"strings"
 	"time"
 
@@ -11,7 +15,9 @@ import (
 	gin_request "github.com/METADIV-GO/ginger/pkg/request"
 	"github.com/METADIV-GO/gorm"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	gonanoid "github.com/matoous/go-nanoid"
+	_gorm "gorm.io/gorm"
 )
 
 type Context[T any] struct {
@@ -24,21 +30,26 @@ type Context[T any] struct {
 	Response *Response
 
 	// use internal
+	opts    *ApiOpts
+	ctx     context.Context
+	tx      *_gorm.DB
 	startAt time.Time
 	hasResp bool
 	isFile  bool
 	status  int
+
+	etag         string
+	lastModified time.Time
 }
 
+var sortFieldRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
 func NewContext[T any](ginCtx *gin.Context) *Context[T] {
-	traceId, err := gonanoid.Generate("2346789abcdefghijkmnopqrtwxyzABCDEFGHJKLMNOPQRTUVWXYZ", 21)
-	if err != nil {
-		panic(err)
-	}
 	return &Context[T]{
 		Engine:  Engine,
 		GinCtx:  ginCtx,
-		TraceId: traceId,
+		TraceId: traceIdOf(ginCtx),
+		ctx:     ginCtx.Request.Context(),
 		Request: gin_request.GinRequest[T](ginCtx),
 		hasResp: false,
 		isFile:  false,
@@ -46,22 +57,213 @@ func NewContext[T any](ginCtx *gin.Context) *Context[T] {
 	}
 }
 
+/*
+traceIdOf returns the trace id of the request, generating it on first use,
+so that the middlewares, the handler and the recovery share the same one.
+*/
+func traceIdOf(ginCtx *gin.Context) string {
+	if traceId := ginCtx.GetString(ctx_key_trace_id); traceId != "" {
+		return traceId
+	}
+	traceId, err := gonanoid.Generate("2346789abcdefghijkmnopqrtwxyzABCDEFGHJKLMNOPQRTUVWXYZ", 21)
+	if err != nil {
+		panic(err)
+	}
+	ginCtx.Set(ctx_key_trace_id, traceId)
+	return traceId
+}
+
+/*
+Context returns the request context.
+It is cancelled when the client disconnects or the route timeout passes.
+*/
+func (c *Context[T]) Context() context.Context {
+	return c.ctx
+}
+
+/*
+DB returns the database bound to the request context,
+so that the queries are cancelled together with the request.
+*/
+func (c *Context[T]) DB() *_gorm.DB {
+	if c.tx != nil {
+		return c.tx
+	}
+	if c.Engine.dbReplicas != nil && c.Engine.dbReplicas.onGet && c.GinCtx.Request.Method == http.MethodGet {
+		return c.DBRead()
+	}
+	if c.Engine.DB == nil {
+		return nil
+	}
+	return c.Engine.DB.WithContext(c.ctx)
+}
+
+/*
+DBRead returns a read replica of the database bound to the request context.
+Inside a transaction, it returns the transaction to read its own writes.
+*/
+func (c *Context[T]) DBRead() *_gorm.DB {
+	if c.tx != nil {
+		return c.tx
+	}
+	db := c.Engine.DBRead()
+	if db == nil {
+		return nil
+	}
+	return db.WithContext(c.ctx)
+}
+
+/*
+MEM returns the memory database bound to the request context.
+*/
+func (c *Context[T]) MEM() *_gorm.DB {
+	if c.Engine.MEM == nil {
+		return nil
+	}
+	return c.Engine.MEM.WithContext(c.ctx)
+}
+
+/*
+DataCache returns the data cache of the engine bound to the request context,
+use NewCache for the typed caches.
+*/
+func (c *Context[T]) DataCache() *ContextCache {
+	return &ContextCache{ctx: c.ctx}
+}
+
+/*
+Database returns the named database registered with RegisterDB bound to the request context.
+*/
+func (c *Context[T]) Database(name string) *_gorm.DB {
+	db := c.Engine.Database(name)
+	if db == nil {
+		return nil
+	}
+	return db.WithContext(c.ctx)
+}
+
+/*
+begin starts the transaction returned by DB() for the rest of the request.
+*/
+func (c *Context[T]) begin() error {
+	if c.Engine.DB == nil {
+		return errors.New("database is not connected")
+	}
+	tx := c.Engine.DB.WithContext(c.ctx).Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	c.tx = tx
+	return nil
+}
+
+/*
+commit commits the transaction if the handler responded successfully,
+otherwise it rolls the transaction back.
+*/
+func (c *Context[T]) commit() error {
+	if c.tx == nil {
+		return nil
+	}
+	tx := c.tx
+	c.tx = nil
+	if !c.hasResp || (!c.isFile && (c.Response == nil || !c.Response.Success)) {
+		return tx.Rollback().Error
+	}
+	return tx.Commit().Error
+}
+
+/*
+rollback rolls the transaction back, e.g. when the handler panics.
+*/
+func (c *Context[T]) rollback() {
+	if c.tx == nil {
+		return
+	}
+	c.tx.Rollback()
+	c.tx = nil
+}
+
 /*
 Page returns the pagination object from the request.
+The size is capped by the max page size of the route, which is also the size without one.
 */
-func (c *Context[T]) Page() *gorm.Pagination {
+func (c *Context[T]) Page() (*gorm.Pagination, error) {
 	page := new(gorm.Pagination)
-	c.GinCtx.Bind(page)
-	return page
+	if err := c.GinCtx.ShouldBindWith(page, binding.Form); err != nil {
+		return nil, errors.New("invalid pagination")
+	}
+	if page.Page < 0 || page.Size < 0 {
+		return nil, errors.New("invalid pagination")
+	}
+	// a request without size gets the max page size, not the whole table
+	if limit := c.maxPageSize(); limit > 0 && (page.Size == 0 || page.Size > limit) {
+		page.Size = limit
+	}
+	if page.Page == 0 && page.Size > 0 {
+		page.Page = 1
+	}
+	return page, nil
 }
 
 /*
-Sort returns the sorting object from the request.
+Sort returns the sorting object from the request, or the default sort of the route.
+The field must be one of the sortable fields of the route.
 */
-func (c *Context[T]) Sort() *gorm.Sorting {
+func (c *Context[T]) Sort() (*gorm.Sorting, error) {
 	sort := new(gorm.Sorting)
-	c.GinCtx.Bind(sort)
-	return sort
+	if err := c.GinCtx.ShouldBindWith(sort, binding.Form); err != nil {
+		return nil, errors.New("invalid sorting")
+	}
+
+	var opt *SortOpt
+	if c.opts != nil {
+		opt = c.opts.Sort
+	}
+	if sort.By == "" {
+		if opt != nil && opt.Default != nil {
+			*sort = *opt.Default
+		}
+		return sort, nil
+	}
+	if opt != nil && len(opt.Fields) > 0 {
+		if !slices.Contains(opt.Fields, sort.By) {
+			return nil, fmt.Errorf("sort by %s is not allowed", sort.By)
+		}
+	} else if !sortFieldRegex.MatchString(sort.By) {
+		return nil, fmt.Errorf("sort by %s is not allowed", sort.By)
+	}
+	return sort, nil
+}
+
+/*
+maxPageSize returns the max page size of the route, zero means no limit.
+*/
+func (c *Context[T]) maxPageSize() int {
+	if c.opts != nil && c.opts.MaxPageSize > 0 {
+		return c.opts.MaxPageSize
+	}
+	return c.Engine.Configs.MaxPageSize
+}
+
+/*
+Cursor returns the cursor pagination of the request, e.g. ?cursor=...&limit=20,
+walking the records in the order of the keys.
+*/
+func (c *Context[T]) Cursor(keys ...CursorKey) (*Cursor, error) {
+	return parseCursor(c.GinCtx.Query("cursor"), c.GinCtx.Query("limit"), c.maxPageSize(), keys)
+}
+
+/*
+Filter returns the filter query of the request, e.g. filter[status]=active&filter[created_at][gte]=2024-01-01,
+restricted to the fields and operators of ApiOpts.Filters.
+*/
+func (c *Context[T]) Filter() (*Filter, error) {
+	var opts []FilterOpt
+	if c.opts != nil {
+		opts = c.opts.Filters
+	}
+	return parseFilter(c.GinCtx.Request.URL.Query(), opts)
 }
 
 /*
@@ -82,9 +284,18 @@ func (c *Context[T]) Authorization() string {
 BearerToken returns the bearer token from the request header.
 */
 func (c *Context[T]) BearerToken() string {
-	auth := c.Authorization()
-	return strings.ReplaceAll(strings.ReplaceAll(strings.ReplaceAll(strings.ReplaceAll(
-		auth, "Bearer", ""), "BEARER", "bearer"), "bearer", ""), " ", "")
+	return bearerTokenOf(c.Authorization())
+}
+
+/*
+bearerTokenOf returns the token of a bearer authorization header, empty for other schemes.
+*/
+func bearerTokenOf(auth string) string {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(auth), " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
 }
 
 /*
@@ -158,6 +369,27 @@ func (c *Context[T]) OK(data any, page ...*gorm.Pagination) {
 	c.status = http.StatusOK
 }
 
+/*
+OKCursor returns a successful response with the cursor pagination.
+*/
+func (c *Context[T]) OKCursor(data any, cursor *CursorPagination) {
+	if c.hasResp {
+		c.LogErr("double response")
+		return
+	}
+
+	c.Response = &Response{
+		Success:  true,
+		TraceId:  c.TraceId,
+		Time:     time.Now().Format(time.RFC3339),
+		Duration: time.Since(c.startAt).Milliseconds(),
+		Cursor:   cursor,
+		Data:     data,
+	}
+	c.hasResp = true
+	c.status = http.StatusOK
+}
+
 /*
 OKFile returns a file response.
 */
@@ -283,3 +515,88 @@ func (c *Context[T]) InternalServerError(message string) {
 	c.hasResp = true
 	c.status = http.StatusInternalServerError
 }
+
+/*
+ServiceUnavailable is a helper function to respond with a service unavailable status code (503).
+*/
+func (c *Context[T]) ServiceUnavailable(message string) {
+	if c.hasResp {
+		c.LogErr("double response")
+		return
+	}
+
+	c.Response = &Response{
+		Success:    false,
+		TraceId:    c.TraceId,
+		Time:       time.Now().Format(time.RFC3339),
+		Duration:   time.Since(c.startAt).Milliseconds(),
+		ErrMessage: message,
+	}
+	c.hasResp = true
+	c.status = http.StatusServiceUnavailable
+}
+
+/*
+GatewayTimeout is a helper function to respond with a gateway timeout status code (504).
+*/
+func (c *Context[T]) GatewayTimeout(message string) {
+	if c.hasResp {
+		c.LogErr("double response")
+		return
+	}
+
+	c.Response = &Response{
+		Success:    false,
+		TraceId:    c.TraceId,
+		Time:       time.Now().Format(time.RFC3339),
+		Duration:   time.Since(c.startAt).Milliseconds(),
+		ErrMessage: message,
+	}
+	c.hasResp = true
+	c.status = http.StatusGatewayTimeout
+}
+
+/*
+NotFound is a helper function to respond with a not found status code (404).
+*/
+func (c *Context[T]) NotFound(message string) {
+	if c.hasResp {
+		c.LogErr("double response")
+		return
+	}
+
+	c.Response = &Response{
+		Success:    false,
+		TraceId:    c.TraceId,
+		Time:       time.Now().Format(time.RFC3339),
+		Duration:   time.Since(c.startAt).Milliseconds(),
+		ErrMessage: message,
+	}
+	c.hasResp = true
+	c.status = http.StatusNotFound
+}
+
+/*
+Set keeps the value of the key for the rest of the request,
+e.g. a middleware hands the authenticated user to the handler:
+
+	ginger.Set(ctx, "user", user)
+*/
+func Set[V any, T any](c *Context[T], key string, value V) {
+	c.GinCtx.Set(key, value)
+}
+
+/*
+Get returns the value of the key kept by Set, and whether it is set with the type:
+
+	user, ok := ginger.Get[*User](ctx, "user")
+*/
+func Get[V any, T any](c *Context[T], key string) (V, bool) {
+	var value V
+	v, ok := c.GinCtx.Get(key)
+	if !ok {
+		return value, false
+	}
+	value, ok = v.(V)
+	return value, ok
+}
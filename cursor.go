@@ -0,0 +1,266 @@
+package ginger
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+/*
+CursorPagination is the cursor info of the response,
+the next and prev cursors are empty when there is no such page.
+*/
+type CursorPagination struct {
+	Limit int    `json:"limit"`
+	Next  string `json:"next,omitempty"`
+	Prev  string `json:"prev,omitempty"`
+}
+
+/*
+CursorKey is a column of the keyset, the last key should be unique, e.g. the primary key.
+*/
+type CursorKey struct {
+	Column string `json:"column"`
+	Desc   bool   `json:"desc"`
+}
+
+/*
+Cursor is the keyset pagination of a request, parsed from ?cursor=&limit=.
+*/
+type Cursor struct {
+	Limit int
+	Keys  []CursorKey
+
+	values []any
+	before bool
+}
+
+/*
+cursorToken is the decoded content of the opaque cursor.
+*/
+type cursorToken struct {
+	Columns []string      `json:"c"`
+	Values  []cursorValue `json:"v"`
+	Before  bool          `json:"b,omitempty"`
+}
+
+/*
+cursorValue keeps the type of the value, so that it is compared as it was read.
+*/
+type cursorValue struct {
+	Type  string `json:"t"`
+	Value string `json:"v"`
+}
+
+func parseCursor(token string, limit string, maxLimit int, keys []CursorKey) (*Cursor, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("cursor requires at least one key")
+	}
+	cursor := &Cursor{
+		Limit: DEFAULT_CURSOR_LIMIT,
+		Keys:  keys,
+	}
+
+	if limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return nil, errors.New("invalid limit")
+		}
+		cursor.Limit = n
+	}
+	if maxLimit > 0 {
+		cursor.Limit = min(cursor.Limit, maxLimit)
+	}
+
+	if token == "" {
+		return cursor, nil
+	}
+	bytes, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	decoded := new(cursorToken)
+	if err := json.Unmarshal(bytes, decoded); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	if !slices.Equal(decoded.Columns, cursor.columns()) || len(decoded.Values) != len(keys) {
+		return nil, errors.New("invalid cursor")
+	}
+	for _, v := range decoded.Values {
+		value, err := v.decode()
+		if err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+		cursor.values = append(cursor.values, value)
+	}
+	cursor.before = decoded.Before
+	return cursor, nil
+}
+
+func (cur *Cursor) columns() []string {
+	columns := make([]string, 0, len(cur.Keys))
+	for _, key := range cur.Keys {
+		columns = append(columns, key.Column)
+	}
+	return columns
+}
+
+/*
+Apply adds the keyset conditions, the order and the limit to the query.
+One more record than the limit is queried to know whether there is another page.
+*/
+func (cur *Cursor) Apply(tx *gorm.DB) *gorm.DB {
+	if len(cur.values) > 0 {
+		// (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ...
+		or := make([]clause.Expression, 0, len(cur.Keys))
+		for i, key := range cur.Keys {
+			and := make([]clause.Expression, 0, i+1)
+			for j := 0; j < i; j++ {
+				and = append(and, clause.Eq{Column: clause.Column{Name: cur.Keys[j].Column}, Value: cur.values[j]})
+			}
+			column := clause.Column{Name: key.Column}
+			if key.Desc != cur.before {
+				and = append(and, clause.Lt{Column: column, Value: cur.values[i]})
+			} else {
+				and = append(and, clause.Gt{Column: column, Value: cur.values[i]})
+			}
+			or = append(or, clause.And(and...))
+		}
+		tx = tx.Where(clause.Or(or...))
+	}
+
+	for _, key := range cur.Keys {
+		tx = tx.Order(clause.OrderByColumn{Column: clause.Column{Name: key.Column}, Desc: key.Desc != cur.before})
+	}
+	return tx.Limit(cur.Limit + 1)
+}
+
+/*
+Find queries the page of records into dest, a pointer to a slice of models,
+and returns the cursors of the next and previous pages.
+*/
+func (cur *Cursor) Find(tx *gorm.DB, dest any) (*CursorPagination, error) {
+	rv := reflect.ValueOf(dest)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Slice {
+		return nil, errors.New("cursor: dest must be a pointer to a slice")
+	}
+
+	result := cur.Apply(tx).Find(dest)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	records := rv.Elem()
+	hasMore := records.Len() > cur.Limit
+	if hasMore {
+		records.Set(records.Slice(0, cur.Limit))
+	}
+	if cur.before {
+		// queried in reverse order to walk backwards
+		swap := reflect.Swapper(records.Interface())
+		for i, j := 0, records.Len()-1; i < j; i, j = i+1, j-1 {
+			swap(i, j)
+		}
+	}
+
+	page := &CursorPagination{Limit: cur.Limit}
+	if records.Len() == 0 {
+		return page, nil
+	}
+
+	var err error
+	if (!cur.before && hasMore) || cur.before {
+		page.Next, err = cur.encode(result, records.Index(records.Len()-1), false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if (!cur.before && len(cur.values) > 0) || (cur.before && hasMore) {
+		page.Prev, err = cur.encode(result, records.Index(0), true)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+/*
+encode returns the opaque cursor pointing after (or before) the record.
+*/
+func (cur *Cursor) encode(result *gorm.DB, record reflect.Value, before bool) (string, error) {
+	if result.Statement.Schema == nil {
+		return "", errors.New("cursor: unknown schema")
+	}
+	record = reflect.Indirect(record)
+
+	token := cursorToken{Columns: cur.columns(), Before: before}
+	for _, key := range cur.Keys {
+		field := result.Statement.Schema.LookUpField(key.Column)
+		if field == nil {
+			return "", fmt.Errorf("cursor: unknown column %s", key.Column)
+		}
+		value, _ := field.ValueOf(result.Statement.Context, record)
+		v, err := encodeCursorValue(value)
+		if err != nil {
+			return "", fmt.Errorf("cursor: column %s: %w", key.Column, err)
+		}
+		token.Values = append(token.Values, v)
+	}
+
+	bytes, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+func encodeCursorValue(value any) (cursorValue, error) {
+	rv := reflect.Indirect(reflect.ValueOf(value))
+	if !rv.IsValid() {
+		return cursorValue{}, errors.New("null value")
+	}
+	if t, ok := rv.Interface().(time.Time); ok {
+		return cursorValue{Type: "time", Value: t.Format(time.RFC3339Nano)}, nil
+	}
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cursorValue{Type: "int", Value: strconv.FormatInt(rv.Int(), 10)}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return cursorValue{Type: "uint", Value: strconv.FormatUint(rv.Uint(), 10)}, nil
+	case reflect.Float32, reflect.Float64:
+		return cursorValue{Type: "float", Value: strconv.FormatFloat(rv.Float(), 'g', -1, 64)}, nil
+	case reflect.Bool:
+		return cursorValue{Type: "bool", Value: strconv.FormatBool(rv.Bool())}, nil
+	case reflect.String:
+		return cursorValue{Type: "string", Value: rv.String()}, nil
+	default:
+		return cursorValue{}, fmt.Errorf("unsupported value type %s", rv.Type())
+	}
+}
+
+func (v cursorValue) decode() (any, error) {
+	switch v.Type {
+	case "time":
+		return time.Parse(time.RFC3339Nano, v.Value)
+	case "int":
+		return strconv.ParseInt(v.Value, 10, 64)
+	case "uint":
+		return strconv.ParseUint(v.Value, 10, 64)
+	case "float":
+		return strconv.ParseFloat(v.Value, 64)
+	case "bool":
+		return strconv.ParseBool(v.Value)
+	case "string":
+		return v.Value, nil
+	default:
+		return nil, fmt.Errorf("unknown cursor value type %s", v.Type)
+	}
+}
@@ -1,5 +1,12 @@
 package ginger
 
+import (
+	"fmt"
+	"slices"
+
+	"gorm.io/gorm"
+)
+
 func NewMigrate(m ...any) {
 	if len(m) == 0 {
 		return
@@ -13,3 +20,118 @@ func NewMemMigrate(m ...any) {
 	}
 	Engine.MemMigrate = append(Engine.MemMigrate, m...)
 }
+
+/*
+NewMigration registers a versioned migration of the database.
+The migrations are applied in version order and recorded in the
+schema history table, e.g. use "20240131_1200_add_user_email" as version.
+*/
+func NewMigration(version, description string, up, down func(tx *gorm.DB) error) {
+	Engine.DBMigrations = append(Engine.DBMigrations, MigrationHandler{
+		Up:          up,
+		Down:        down,
+		Version:     version,
+		Description: description,
+	})
+}
+
+/*
+RegisterDB registers an additional named database, connected on startup
+and retrieved with Engine.Database(name) or ctx.Database(name).
+The options left empty are read from the GORM_<NAME>_* environment variables.
+*/
+func RegisterDB(name, dbType string, opts ...*DBOpts) error {
+	if name == "" || name == "db" || name == "mem" {
+		return fmt.Errorf("invalid database name %q", name)
+	}
+	if Engine.databaseHandler(name) != nil {
+		return fmt.Errorf("database %q is already registered", name)
+	}
+	if Engine.hasHealthCheck(name) {
+		return fmt.Errorf("database %q has the name of a health check", name)
+	}
+	if !slices.Contains(dbTypes, dbType) {
+		return fmt.Errorf("unknown database type %q", dbType)
+	}
+
+	var opt *DBOpts
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
+	Engine.Databases = append(Engine.Databases, DatabaseHandler{
+		Name:       name,
+		Type:       dbType,
+		Opts:       opt,
+		Migrate:    make([]any, 0),
+		Migrations: make([]MigrationHandler, 0),
+	})
+	return nil
+}
+
+/*
+NewDatabaseMigrate registers the models auto migrated on the named database.
+The database must be registered with RegisterDB first.
+*/
+func NewDatabaseMigrate(name string, m ...any) {
+	handler := Engine.databaseHandler(name)
+	if handler == nil {
+		panic("database " + name + " is not registered")
+	}
+	handler.Migrate = append(handler.Migrate, m...)
+}
+
+/*
+NewDatabaseMigration registers a versioned migration of the named database.
+The database must be registered with RegisterDB first.
+*/
+func NewDatabaseMigration(name, version, description string, up, down func(tx *gorm.DB) error) {
+	handler := Engine.databaseHandler(name)
+	if handler == nil {
+		panic("database " + name + " is not registered")
+	}
+	handler.Migrations = append(handler.Migrations, MigrationHandler{
+		Up:          up,
+		Down:        down,
+		Version:     version,
+		Description: description,
+	})
+}
+
+/*
+Database returns the named database registered with RegisterDB,
+or nil when it is not registered or not connected.
+*/
+func (e *engine) Database(name string) *gorm.DB {
+	return e.databases[name]
+}
+
+func (e *engine) databaseHandler(name string) *DatabaseHandler {
+	for i := range e.Databases {
+		if e.Databases[i].Name == name {
+			return &e.Databases[i]
+		}
+	}
+	return nil
+}
+
+/*
+setupDatabases connects the named databases, and auto migrates them with migrate.
+*/
+func (e *engine) setupDatabases(migrate bool) error {
+	e.databases = make(map[string]*gorm.DB, len(e.Databases))
+	for _, handler := range e.Databases {
+		db, _, err := e.connect(handler.Name, handler.Type, handler.Opts)
+		if err != nil {
+			return err
+		}
+		e.databases[handler.Name] = db
+		if !migrate {
+			continue
+		}
+		if err := autoMigrate(handler.Name, db, handler.Migrate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package ginger
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+/*
+Cache is a typed key/value cache of the application data, e.g.
+
+	var users = ginger.NewCache[User]("users")
+
+	user, err := users.GetOrLoad(ctx.Context(), id, time.Minute, func(ctx context.Context) (User, error) {
+		...
+	})
+
+The values are kept as json in the store, the data cache store of the engine unless given.
+*/
+type Cache[T any] struct {
+	name  string
+	store CacheStore
+	group singleflight.Group
+}
+
+/*
+NewCache returns the cache of the name, the keys of different names do not collide in a shared store.
+*/
+func NewCache[T any](name string, store ...CacheStore) *Cache[T] {
+	cache := &Cache[T]{name: name}
+	if len(store) > 0 {
+		cache.store = store[0]
+	}
+	return cache
+}
+
+/*
+SetDataCacheStore sets the default store of the data caches, NewCache and ctx.DataCache.
+The default store is in memory, per process, limited to DEFAULT_CACHE_MAX_BYTES,
+and apart from the one of the responses so that the page traffic does not evict the data.
+*/
+func (e *engine) SetDataCacheStore(store CacheStore) {
+	e.dataCacheStore = store
+}
+
+/*
+dataCacheStoreOf returns the default store of the data caches, creating the memory store on first use.
+*/
+func (e *engine) dataCacheStoreOf() CacheStore {
+	e.dataCacheOnce.Do(func() {
+		if e.dataCacheStore == nil {
+			e.dataCacheStore = NewMemoryCacheStore(DEFAULT_CACHE_MAX_BYTES)
+		}
+	})
+	return e.dataCacheStore
+}
+
+/*
+storeOf returns the store of the cache, resolved on use so that Engine.SetDataCacheStore can be called later.
+*/
+func (c *Cache[T]) storeOf() CacheStore {
+	if c.store != nil {
+		return c.store
+	}
+	return Engine.dataCacheStoreOf()
+}
+
+func (c *Cache[T]) key(key string) string {
+	return "ginger_cache:data:" + c.name + ":" + key
+}
+
+/*
+Get returns the value of the key, and whether it is cached.
+*/
+func (c *Cache[T]) Get(ctx context.Context, key string) (T, bool, error) {
+	var value T
+	data, ok, err := c.storeOf().Get(ctx, c.key(key))
+	if err != nil || !ok {
+		return value, false, err
+	}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return value, false, err
+	}
+	return value, true, nil
+}
+
+/*
+Set caches the value of the key for the ttl, zero keeps it until it is evicted.
+*/
+func (c *Cache[T]) Set(ctx context.Context, key string, value T, ttl time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return c.storeOf().Set(ctx, c.key(key), data, ttl)
+}
+
+/*
+Delete removes the value of the key.
+*/
+func (c *Cache[T]) Delete(ctx context.Context, key string) error {
+	return c.storeOf().Delete(ctx, c.key(key))
+}
+
+/*
+GetOrLoad returns the cached value of the key, or loads and caches it for the ttl.
+The concurrent misses of the key in the process share a single load,
+which is not cancelled with the context of the caller.
+A store failure is logged and the value is loaded without the cache.
+*/
+func (c *Cache[T]) GetOrLoad(ctx context.Context, key string, ttl time.Duration, load func(ctx context.Context) (T, error)) (T, error) {
+	value, ok, err := c.Get(ctx, key)
+	if err != nil {
+		Engine.LogErr("cache ", c.name, ": ", err)
+	}
+	if ok {
+		return value, nil
+	}
+
+	result, err, _ := c.group.Do(key, func() (any, error) {
+		ctx := context.WithoutCancel(ctx)
+		value, err := load(ctx)
+		if err != nil {
+			return value, err
+		}
+		if err := c.Set(ctx, key, value, ttl); err != nil {
+			Engine.LogErr("cache ", c.name, ": ", err)
+		}
+		return value, nil
+	})
+	// a nil interface T is returned as nil, not as a T
+	value, _ = result.(T)
+	return value, err
+}
+
+var contextCache = NewCache[json.RawMessage]("context")
+
+/*
+ContextCache is the untyped data cache of the engine, bound to the context of the request.
+*/
+type ContextCache struct {
+	ctx context.Context
+}
+
+/*
+Get decodes the value of the key into dest, and returns whether it is cached.
+*/
+func (c *ContextCache) Get(key string, dest any) (bool, error) {
+	data, ok, err := contextCache.Get(c.ctx, key)
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, json.Unmarshal(data, dest)
+}
+
+/*
+Set caches the value of the key for the ttl, zero keeps it until it is evicted.
+*/
+func (c *ContextCache) Set(key string, value any, ttl time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return contextCache.Set(c.ctx, key, data, ttl)
+}
+
+/*
+Delete removes the value of the key.
+*/
+func (c *ContextCache) Delete(key string) error {
+	return contextCache.Delete(c.ctx, key)
+}
+
+/*
+GetOrLoad decodes the cached value of the key into dest, or loads and caches it for the ttl,
+see Cache.GetOrLoad.
+*/
+func (c *ContextCache) GetOrLoad(key string, dest any, ttl time.Duration, load func() (any, error)) error {
+	data, err := contextCache.GetOrLoad(c.ctx, key, ttl, func(ctx context.Context) (json.RawMessage, error) {
+		value, err := load()
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(value)
+	})
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dest)
+}
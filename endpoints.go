@@ -1,9 +1,13 @@
 package ginger
 
 import (
+	"context"
+	"fmt"
 	"time"
 
+	"github.com/METADIV-GO/gorm"
 	"github.com/gorilla/websocket"
+	"github.com/ulule/limiter/v3"
 )
 
 type TypescriptOpt struct {
@@ -13,21 +17,64 @@ type TypescriptOpt struct {
 	Forms        []string `json:"forms"`
 	Body         string   `json:"body"`
 	Response     string   `json:"response"`
+	// Types are declared with the models, e.g. a body picking the writable fields of a model.
+	Types []TypescriptType `json:"types"`
+}
+
+/*
+TypescriptType is a type picking the json fields of a model, e.g.
+
+	export type CreateUser = Pick<User, 'name' | 'email'>;
+
+With Optional, all the fields are optional, e.g. for a partial update.
+*/
+type TypescriptType struct {
+	Name     string   `json:"name"`
+	Model    any      `json:"-"`
+	Fields   []string `json:"fields"`
+	Optional bool     `json:"optional"`
 }
 
 type RateLimitOpt struct {
 	Rate     int64         `json:"rate"`
 	Duration time.Duration `json:"duration"`
+	// Store keeps the counters, the engine's rate limit store by default.
+	Store limiter.Store `json:"-"`
+	// Key returns the client the requests are counted for, RateLimitByIP by default.
+	Key RateLimitKeyFunc `json:"-"`
 }
 
 type CacheOpt struct {
 	Duration time.Duration `json:"duration"`
+	// VaryHeaders are the request headers the response depends on, e.g. Authorization or X-Locale.
+	VaryHeaders []string `json:"vary_headers"`
+	// Tags invalidate the cached responses together, see Engine.ResponseCache().InvalidateTag.
+	Tags []string `json:"tags"`
+	// Store keeps the responses, the engine's cache store by default.
+	Store CacheStore `json:"-"`
+}
+
+type SortOpt struct {
+	// Fields are the sortable fields, any other sort is rejected.
+	Fields []string `json:"fields"`
+	// Default is the sort applied when the request has none.
+	Default *gorm.Sorting `json:"default"`
 }
 
 type ApiOpts struct {
-	RateLimit  *RateLimitOpt  `json:"rate_limit"`
-	Cache      *CacheOpt      `json:"cache"`
-	Typescript *TypescriptOpt `json:"typescript"`
+	Timeout       time.Duration   `json:"timeout"`
+	Transactional bool            `json:"transactional"`
+	Auth          bool            `json:"auth"`
+	RateLimit     *RateLimitOpt   `json:"rate_limit"`
+	RateLimits    []*RateLimitOpt `json:"rate_limits"`
+	MaxConcurrent int             `json:"max_concurrent"`
+	Cache         *CacheOpt       `json:"cache"`
+	MaxPageSize   int             `json:"max_page_size"`
+	Sort          *SortOpt        `json:"sort"`
+	Filters       []FilterOpt     `json:"filters"`
+	Typescript    *TypescriptOpt  `json:"typescript"`
+	// Middlewares run in declared order after the global ones matching the route.
+	Middlewares []func(ctx *Context[struct{}]) `json:"-"`
 }
 
 func GET[T any](path string, handler func(ctx *Context[T]), opts ...*ApiOpts) {
@@ -37,7 +84,7 @@ func GET[T any](path string, handler func(ctx *Context[T]), opts ...*ApiOpts) {
 	}
 
 	Engine.ApiHandlers = append(Engine.ApiHandlers, ApiHandler{
-		Handler: apiToHandler[T](handler),
+		Handler: apiToHandler[T](handler, opt),
 		Method:  "GET",
 		Path:    path,
 		Opts:    opt,
@@ -51,7 +98,7 @@ func POST[T any](path string, handler func(ctx *Context[T]), opts ...*ApiOpts) {
 	}
 
 	Engine.ApiHandlers = append(Engine.ApiHandlers, ApiHandler{
-		Handler: apiToHandler[T](handler),
+		Handler: apiToHandler[T](handler, opt),
 		Method:  "POST",
 		Path:    path,
 		Opts:    opt,
@@ -65,7 +112,7 @@ func PUT[T any](path string, handler func(ctx *Context[T]), opts ...*ApiOpts) {
 	}
 
 	Engine.ApiHandlers = append(Engine.ApiHandlers, ApiHandler{
-		Handler: apiToHandler[T](handler),
+		Handler: apiToHandler[T](handler, opt),
 		Method:  "PUT",
 		Path:    path,
 		Opts:    opt,
@@ -79,23 +126,36 @@ func DELETE[T any](path string, handler func(ctx *Context[T]), opts ...*ApiOpts)
 	}
 
 	Engine.ApiHandlers = append(Engine.ApiHandlers, ApiHandler{
-		Handler: apiToHandler[T](handler),
+		Handler: apiToHandler[T](handler, opt),
 		Method:  "DELETE",
 		Path:    path,
 		Opts:    opt,
 	})
 }
 
-func WS[T any](path string, handler func(ctx *Context[T], ws *websocket.Conn)) {
+/*
+WS registers the websocket handler, the middlewares run in declared order after the global ones matching the path.
+*/
+func WS[T any](path string, handler func(ctx *Context[T], ws *websocket.Conn), middlewares ...func(ctx *Context[struct{}])) {
 	Engine.WsHandlers = append(Engine.WsHandlers, WsHandler{
-		Handler: wsToHandler[T](handler),
-		Path:    path,
+		Handler:     wsToHandler[T](handler),
+		Path:        path,
+		Middlewares: middlewares,
 	})
 }
 
-func Corn(pattern string, handler func(), initExec bool) {
+/*
+Corn registers the cron job, named after its handler function unless a name is given,
+e.g. in the logs and the metrics of its runs.
+*/
+func Corn(pattern string, handler func(), initExec bool, name ...string) {
+	n := funcName(handler)
+	if len(name) > 0 && name[0] != "" {
+		n = name[0]
+	}
 	Engine.CronHandlers = append(Engine.CronHandlers, CornHandler{
 		Handler:  handler,
+		Name:     n,
 		InitExec: initExec,
 		Pattern:  pattern,
 	})
@@ -108,10 +168,82 @@ func InitJob(handler func(), after bool) {
 	})
 }
 
-func Middleware(handler func(ctx *Context[struct{}]), matchPaths []string, skipPaths []string) {
+/*
+HealthCheck registers a readiness check, reported under its name.
+The name must be unique and not one of the database checks: db, mem or a registered database.
+*/
+func HealthCheck(name string, handler func(ctx context.Context) error, timeout ...time.Duration) error {
+	if name == "" || name == "db" || name == "mem" || Engine.databaseHandler(name) != nil {
+		return fmt.Errorf("invalid health check name %q", name)
+	}
+	if Engine.hasHealthCheck(name) {
+		return fmt.Errorf("health check %q is already registered", name)
+	}
+
+	var t time.Duration
+	if len(timeout) > 0 {
+		t = timeout[0]
+	}
+
+	Engine.HealthChecks = append(Engine.HealthChecks, HealthCheckHandler{
+		Handler: handler,
+		Name:    name,
+		Timeout: t,
+	})
+	return nil
+}
+
+/*
+Middleware runs the handler before the routes matching one of the match path regexps
+and none of the skip path regexps.
+The middlewares run by ascending priority, 0 by default, then in registration order.
+*/
+func Middleware(handler func(ctx *Context[struct{}]), matchPaths []string, skipPaths []string, priority ...int) {
+	var p int
+	if len(priority) > 0 {
+		p = priority[0]
+	}
+
 	Engine.Middlewares = append(Engine.Middlewares, MiddlewareHandler{
-		Handler:    middlewareToHandler(handler),
-		MatchPaths: matchPaths,
-		SkipPaths:  skipPaths,
+		Handler:      middlewareToHandler(handler),
+		Name:         funcName(handler),
+		Priority:     p,
+		MatchPaths:   matchPaths,
+		SkipPaths:    skipPaths,
+		matchRegexps: compileRegexps(matchPaths),
+		skipRegexps:  compileRegexps(skipPaths),
+	})
+}
+
+/*
+RateLimit limits the requests of the routes matching the path regexps, all the routes without any.
+The counters are shared by the matching routes, e.g. a quota for the whole /admin group.
+A nil limit, or one without a positive rate and duration, sets no limit.
+*/
+func RateLimit(limit *RateLimitOpt, matchPaths ...string) {
+	if !limit.valid() {
+		return
+	}
+	Engine.RateLimits = append(Engine.RateLimits, RateLimitHandler{
+		Limits:       []*RateLimitOpt{limit},
+		MatchPaths:   matchPaths,
+		matchRegexps: compileRegexps(matchPaths),
+	})
+}
+
+/*
+ConcurrencyLimit limits the requests served at the same time by the routes matching the path regexps,
+all the routes without any. The requests over the limit are rejected with 503.
+A max of zero or less sets no limit.
+*/
+func ConcurrencyLimit(max int, matchPaths ...string) {
+	if max <= 0 {
+		return
+	}
+	Engine.ConcurrencyLimits = append(Engine.ConcurrencyLimits, ConcurrencyLimitHandler{
+		Max:          max,
+		MatchPaths:   matchPaths,
+		slots:        make(chan struct{}, max),
+		matchRegexps: compileRegexps(matchPaths),
 	})
 }
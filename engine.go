@@ -1,21 +1,25 @@
 package ginger
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
-	"regexp"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/METADIV-GO/ginger/pkg/logger"
-	"github.com/METADIV-GO/gorm/conn"
-	"github.com/gin-contrib/cache"
-	"github.com/gin-contrib/cache/persistence"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/robfig/cron"
 	limiter "github.com/ulule/limiter/v3"
-	_gin "github.com/ulule/limiter/v3/drivers/middleware/gin"
-	"github.com/ulule/limiter/v3/drivers/store/memory"
 	"gorm.io/gorm"
 )
 
@@ -32,31 +36,74 @@ type engine struct {
 	EnvironmentKeys []string     `json:"environment_keys"`
 	Configs         engineConfig `json:"configs"`
 
-	DBMigrate  []any `json:"db_migrate"`
-	MemMigrate []any `json:"mem_migrate"`
-
-	ApiHandlers  []ApiHandler        `json:"api_handlers"`
-	WsHandlers   []WsHandler         `json:"ws_handlers"`
-	CronHandlers []CornHandler       `json:"cron_handlers"`
-	InitJobs     []InitJobHandler    `json:"init_jobs"`
-	Middlewares  []MiddlewareHandler `json:"middlewares"`
+	DBMigrate    []any              `json:"db_migrate"`
+	MemMigrate   []any              `json:"mem_migrate"`
+	DBMigrations []MigrationHandler `json:"db_migrations"`
+	Databases    []DatabaseHandler  `json:"databases"`
+	Seeds        []SeedHandler      `json:"seeds"`
+
+	ApiHandlers  []ApiHandler         `json:"api_handlers"`
+	WsHandlers   []WsHandler          `json:"ws_handlers"`
+	CronHandlers []CornHandler        `json:"cron_handlers"`
+	InitJobs     []InitJobHandler     `json:"init_jobs"`
+	Middlewares  []MiddlewareHandler  `json:"middlewares"`
+	HealthChecks []HealthCheckHandler `json:"health_checks"`
+
+	RateLimits        []RateLimitHandler        `json:"rate_limits"`
+	ConcurrencyLimits []ConcurrencyLimitHandler `json:"concurrency_limits"`
+
+	metrics        *metrics
+	dbReplicas     *replicaPool
+	databases      map[string]*gorm.DB
+	cron           *cron.Cron
+	ready          atomic.Bool
+	errorReporter  ErrorReporter
+	rateLimitStore limiter.Store
+	inFlight       atomic.Int64
+	cacheStore     CacheStore
+	cacheOnce      sync.Once
+	dataCacheStore CacheStore
+	dataCacheOnce  sync.Once
+	jwtOpts        *JWTOpts
+	jwtOnce        sync.Once
+	jwtVerifier    *jwtVerifier
+	jwtErr         error
 }
 
 type engineConfig struct {
-	DBType  string
-	MEMType string
+	DBType          string
+	MEMType         string
+	DBOpts          *DBOpts
+	MEMOpts         *DBOpts
+	Metrics         bool
+	MetricsPath     string
+	Timeout         time.Duration
+	MaxPageSize     int
+	MaxInFlight     int
+	MigrateOnStart  bool
+	ConnectRetry    time.Duration
+	LivenessPath    string
+	ReadinessPath   string
+	ShutdownDelay   time.Duration
+	ShutdownTimeout time.Duration
 }
 
 func newEngine() *engine {
-	return &engine{
-		Gin:          gin.Default(),
-		ApiHandlers:  make([]ApiHandler, 0),
-		WsHandlers:   make([]WsHandler, 0),
-		CronHandlers: make([]CornHandler, 0),
-		InitJobs:     make([]InitJobHandler, 0),
-		Middlewares:  make([]MiddlewareHandler, 0),
-		DBMigrate:    make([]any, 0),
-		MemMigrate:   make([]any, 0),
+	e := &engine{
+		Gin:               gin.New(),
+		ApiHandlers:       make([]ApiHandler, 0),
+		WsHandlers:        make([]WsHandler, 0),
+		CronHandlers:      make([]CornHandler, 0),
+		InitJobs:          make([]InitJobHandler, 0),
+		Middlewares:       make([]MiddlewareHandler, 0),
+		HealthChecks:      make([]HealthCheckHandler, 0),
+		RateLimits:        make([]RateLimitHandler, 0),
+		ConcurrencyLimits: make([]ConcurrencyLimitHandler, 0),
+		DBMigrate:         make([]any, 0),
+		MemMigrate:        make([]any, 0),
+		DBMigrations:      make([]MigrationHandler, 0),
+		Databases:         make([]DatabaseHandler, 0),
+		Seeds:             make([]SeedHandler, 0),
 		EnvironmentKeys: []string{
 			"GIN_MODE",
 			"GIN_HOST",
@@ -66,14 +113,48 @@ func newEngine() *engine {
 			"GORM_USERNAME",
 			"GORM_PASSWORD",
 			"GORM_DATABASE",
+			"GORM_SQLITE_PATH",
+			"GORM_DSN",
+			"GORM_MAX_OPEN_CONNS",
+			"GORM_MAX_IDLE_CONNS",
+			"GORM_CONN_MAX_LIFETIME",
+			"GORM_CONN_MAX_IDLE_TIME",
+			"GORM_LOG_LEVEL",
+			"GORM_TLS_MODE",
+			"GORM_TLS_CA",
+			"GORM_TLS_CERT",
+			"GORM_TLS_KEY",
+			"GORM_TLS_SERVER_NAME",
+			"GORM_REPLICAS",
+			"GORM_MAX_REPLICA_LAG",
+			"GORM_REPLICA_ON_GET",
+			"REDIS_ADDR",
+			"REDIS_USERNAME",
+			"REDIS_PASSWORD",
+			"REDIS_DB",
+			"REDIS_TLS",
+			"JWT_SECRET",
+			"JWT_PUBLIC_KEY",
+			"JWT_JWKS_FILE",
+			"JWT_ISSUER",
+			"JWT_AUDIENCE",
 			"GORM_SILENT",
 			"GORM_ENCRYPT_KEY",
 		},
 		Configs: engineConfig{
-			DBType:  DB_TYPE_MYSQL,
-			MEMType: DB_TYPE_MEM,
+			DBType:          DB_TYPE_MYSQL,
+			MEMType:         DB_TYPE_MEM,
+			LivenessPath:    "/healthz",
+			ReadinessPath:   "/readyz",
+			ShutdownDelay:   DEFAULT_SHUTDOWN_DELAY,
+			ShutdownTimeout: DEFAULT_SHUTDOWN_TIMEOUT,
+			MaxPageSize:     DEFAULT_MAX_PAGE_SIZE,
+			MigrateOnStart:  true,
+			ConnectRetry:    DEFAULT_CONNECT_RETRY,
 		},
 	}
+	e.Gin.Use(gin.Logger(), e.recovery())
+	return e
 }
 
 /*
@@ -98,17 +179,110 @@ func (e *engine) LogDebug(msg ...any) {
 }
 
 /*
-SetDBType sets the database type.
+SetDBType sets the database type, one of the DB_TYPE_* constants.
 */
-func (e *engine) SetDBType(dbType string) {
+func (e *engine) SetDBType(dbType string) error {
+	if !slices.Contains(dbTypes, dbType) {
+		return fmt.Errorf("unknown database type %q", dbType)
+	}
 	e.Configs.DBType = dbType
+	return nil
 }
 
 /*
-SetMEMType sets the memory type.
+SetMEMType sets the memory type, one of the DB_TYPE_* constants.
 */
-func (e *engine) SetMEMType(memType string) {
+func (e *engine) SetMEMType(memType string) error {
+	if !slices.Contains(dbTypes, memType) {
+		return fmt.Errorf("unknown database type %q", memType)
+	}
 	e.Configs.MEMType = memType
+	return nil
+}
+
+/*
+SetDBOpts sets the connection options of the database.
+The options left empty are read from the GORM_* environment variables.
+*/
+func (e *engine) SetDBOpts(opts *DBOpts) {
+	e.Configs.DBOpts = opts
+}
+
+/*
+SetMEMOpts sets the connection options of the memory database.
+The options left empty are read from the GORM_MEM_* environment variables.
+*/
+func (e *engine) SetMEMOpts(opts *DBOpts) {
+	e.Configs.MEMOpts = opts
+}
+
+/*
+SetConnectRetry sets how long the connection to the databases is retried
+on startup before Run fails.
+*/
+func (e *engine) SetConnectRetry(period time.Duration) {
+	e.Configs.ConnectRetry = period
+}
+
+/*
+EnableMetrics exposes the prometheus metrics endpoint.
+The default path is /metrics.
+*/
+func (e *engine) EnableMetrics(path ...string) {
+	e.Configs.Metrics = true
+	if len(path) > 0 && path[0] != "" {
+		e.Configs.MetricsPath = path[0]
+	} else {
+		e.Configs.MetricsPath = "/metrics"
+	}
+}
+
+/*
+SetMigrateOnStart sets whether the versioned migrations are applied when the application starts.
+When disabled, they are applied with the migrate command, e.g. `./app migrate up`.
+*/
+func (e *engine) SetMigrateOnStart(migrate bool) {
+	e.Configs.MigrateOnStart = migrate
+}
+
+/*
+SetTimeout sets the default timeout of the api handlers.
+It is overridden by ApiOpts.Timeout, zero means no timeout.
+*/
+func (e *engine) SetTimeout(timeout time.Duration) {
+	e.Configs.Timeout = timeout
+}
+
+/*
+SetMaxPageSize sets the default max page size of ctx.Page() and ctx.Cursor().
+It is overridden by ApiOpts.MaxPageSize, zero means no limit.
+*/
+func (e *engine) SetMaxPageSize(size int) {
+	e.Configs.MaxPageSize = size
+}
+
+/*
+SetErrorReporter sets the hook invoked with every panic recovered from the handlers.
+*/
+func (e *engine) SetErrorReporter(reporter ErrorReporter) {
+	e.errorReporter = reporter
+}
+
+/*
+SetShutdown sets how long the application keeps serving as not ready
+before shutting down, DEFAULT_SHUTDOWN_DELAY by default, and how long it waits for in-flight requests.
+The delay must leave the orchestrator time to see the readiness probe fail, zero shuts down at once.
+*/
+func (e *engine) SetShutdown(delay, timeout time.Duration) {
+	e.Configs.ShutdownDelay = delay
+	e.Configs.ShutdownTimeout = timeout
+}
+
+/*
+Ready reports whether the application is ready to accept traffic.
+*/
+func (e *engine) Ready() bool {
+	return e.ready.Load()
 }
 
 /*
@@ -123,16 +297,44 @@ func (e *engine) GenerateTypescript() {
 }
 
 /*
-Run starts the application.
+Run starts the application and blocks until it is shut down.
+When started as `<app> migrate ...`, it runs the migrate command and returns instead,
+only the versioned migrations change the schema then, the models are not auto migrated.
+The error of the setup, the migrate command or the server is logged before it is returned.
 */
-func (e *engine) Run() {
-	e.setupDB()
+func (e *engine) Run() error {
+	err := e.run()
+	if err != nil {
+		e.LogErr("run: ", err)
+	}
+	return err
+}
+
+func (e *engine) run() error {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		// the schema is only changed by the versioned migrations of the command
+		if err := e.setupDB(false); err != nil {
+			return err
+		}
+		return e.migrateCommand(os.Args[2:])
+	}
+	if err := e.Setup(); err != nil {
+		return err
+	}
+	if e.hasAuth() {
+		if _, err := e.jwtVerifierOf(); err != nil {
+			return fmt.Errorf("jwt: %w", err)
+		}
+	}
 	e.setupCors()
+	e.setupMetrics()
+	e.setupHealth()
 	e.executeBeforeJobs()
 	e.registerApis()
 	e.registerWs()
 	e.registerCronJobs()
 	e.executeAfterJobs()
+	e.ready.Store(true)
 
 	host := Env("GIN_HOST")
 	port := Env("GIN_PORT")
@@ -142,56 +344,156 @@ func (e *engine) Run() {
 	if port == "" {
 		port = "5000"
 	}
-	e.Gin.Run(host + ":" + port)
+	return e.serve(host + ":" + port)
 }
 
-func (e *engine) setupDB() {
-	var silent bool
-	if Env("GORM_SILENT") == "true" {
-		silent = true
-	} else {
-		silent = Env("GIN_MODE") == "release"
+/*
+Setup connects the databases, migrates and seeds them.
+It is called by Run, and can be called alone, e.g. in tests.
+*/
+func (e *engine) Setup() error {
+	if err := e.setupDB(true); err != nil {
+		return err
+	}
+	if err := e.setupMigrations(); err != nil {
+		return err
 	}
+	return e.Seed()
+}
 
-	var err error
-	switch e.Configs.DBType {
-	case DB_TYPE_MYSQL:
-		e.DB, err = conn.QuickMySQL(silent)
-		if err != nil {
-			panic(err)
+/*
+serve listens on the address until the server fails or
+the process receives SIGINT or SIGTERM, then shuts down gracefully.
+*/
+func (e *engine) serve(addr string) error {
+	server := &http.Server{
+		Addr:    addr,
+		Handler: e.Gin,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		e.LogInfo("listening and serving HTTP on ", addr)
+		errCh <- server.ListenAndServe()
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-errCh:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("serve %s: %w", addr, err)
 		}
-	case DB_TYPE_PGSQL:
-		e.DB, err = conn.QuickPostgreSQL(silent)
-		if err != nil {
-			panic(err)
+	case <-quit:
+		e.shutdown(server)
+	}
+	return nil
+}
+
+/*
+shutdown flips the readiness, waits for the shutdown delay so that the load
+balancer stops sending traffic, drains the in-flight requests and releases
+the resources.
+*/
+func (e *engine) shutdown(server *http.Server) {
+	e.ready.Store(false)
+	e.LogInfo("shutting down")
+	time.Sleep(e.Configs.ShutdownDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.Configs.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		e.LogErr("server shutdown: ", err)
+	}
+
+	if e.cron != nil {
+		e.cron.Stop()
+	}
+	e.dbReplicas.stop()
+	dbs := append([]*gorm.DB{e.DB, e.MEM}, e.dbReplicas.dbs()...)
+	for _, handler := range e.Databases {
+		dbs = append(dbs, e.databases[handler.Name])
+	}
+	for _, db := range dbs {
+		if db == nil {
+			continue
 		}
-	case DB_TYPE_MEM:
-		e.DB, err = conn.SqliteMem(silent)
-		if err != nil {
-			panic(err)
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
 		}
 	}
+}
 
-	switch e.Configs.MEMType {
-	case DB_TYPE_MYSQL:
-		e.MEM, err = conn.QuickMySQL(silent)
-		if err != nil {
-			panic(err)
+func (e *engine) setupDB(migrate bool) error {
+	var err error
+	var dbOpts *DBOpts
+	e.DB, dbOpts, err = e.connect("db", e.Configs.DBType, e.Configs.DBOpts)
+	if err != nil {
+		return err
+	}
+	if err := e.setupReplicas(dbOpts); err != nil {
+		return err
+	}
+	e.MEM, _, err = e.connect("mem", e.Configs.MEMType, e.Configs.MEMOpts)
+	if err != nil {
+		return err
+	}
+
+	if err := e.setupDatabases(migrate); err != nil {
+		return err
+	}
+	if !migrate {
+		return nil
+	}
+	if err := autoMigrate("db", e.DB, e.DBMigrate); err != nil {
+		return err
+	}
+	return autoMigrate("mem", e.MEM, e.MemMigrate)
+}
+
+func autoMigrate(name string, db *gorm.DB, models []any) error {
+	if len(models) == 0 {
+		return nil
+	}
+	if db == nil {
+		return fmt.Errorf("auto migrate %s: database type is none", name)
+	}
+	if err := db.AutoMigrate(models...); err != nil {
+		return fmt.Errorf("auto migrate %s: %w", name, err)
+	}
+	return nil
+}
+
+func (e *engine) setupMigrations() error {
+	if !e.Configs.MigrateOnStart {
+		return nil
+	}
+
+	names := make([]string, 0)
+	if len(e.DBMigrations) > 0 {
+		names = append(names, "db")
+	}
+	for _, handler := range e.Databases {
+		if len(handler.Migrations) > 0 {
+			names = append(names, handler.Name)
 		}
-	case DB_TYPE_PGSQL:
-		e.MEM, err = conn.QuickPostgreSQL(silent)
+	}
+
+	for _, name := range names {
+		m, err := e.migrator(name)
 		if err != nil {
-			panic(err)
+			return err
+		}
+		versions, err := m.Up(false)
+		for _, v := range versions {
+			e.LogInfo("migrate ", name, " up: ", v)
 		}
-	case DB_TYPE_MEM:
-		e.MEM, err = conn.SqliteMem(silent)
 		if err != nil {
-			panic(err)
+			return err
 		}
 	}
-
-	e.DB.AutoMigrate(e.DBMigrate...)
-	e.MEM.AutoMigrate(e.MemMigrate...)
+	return nil
 }
 
 func (e *engine) setupCors() {
@@ -215,6 +517,23 @@ func (e *engine) setupCors() {
 	}))
 }
 
+func (e *engine) setupMetrics() {
+	if !e.Configs.Metrics {
+		return
+	}
+	e.metrics = newMetrics()
+	e.metrics.registerDB("db", e.DB)
+	e.metrics.registerDB("mem", e.MEM)
+	for i, db := range e.dbReplicas.dbs() {
+		e.metrics.registerDB("db_replica_"+strconv.Itoa(i), db)
+	}
+	for _, handler := range e.Databases {
+		e.metrics.registerDB(handler.Name, e.databases[handler.Name])
+	}
+	e.Gin.Use(e.metrics.middleware())
+	e.Gin.GET(e.Configs.MetricsPath, e.metrics.handler())
+}
+
 func (e *engine) executeBeforeJobs() {
 	for _, job := range e.InitJobs {
 		if !job.After {
@@ -232,110 +551,46 @@ func (e *engine) executeAfterJobs() {
 }
 
 func (e *engine) registerCronJobs() {
-	cron := cron.New()
+	e.cron = cron.New()
 	for i := range e.CronHandlers {
-		cron.AddFunc(e.CronHandlers[i].Pattern, e.CronHandlers[i].Handler)
+		handler := e.cronJob(e.CronHandlers[i])
+		e.cron.AddFunc(e.CronHandlers[i].Pattern, handler)
 		if e.CronHandlers[i].InitExec {
-			e.CronHandlers[i].Handler()
+			handler()
 		}
 	}
-	cron.Start()
+	e.cron.Start()
+}
+
+/*
+cronJob wraps the cron handler to record its runs, duration and panics.
+*/
+func (e *engine) cronJob(job CornHandler) func() {
+	return func() {
+		startAt := time.Now()
+		failed := true
+		defer func() {
+			if r := recover(); r != nil {
+				e.LogErr("cron job ", job.Name, " panic: ", r)
+			}
+			e.metrics.cronRun(job.Name, job.Pattern, time.Since(startAt), failed)
+		}()
+		job.Handler()
+		failed = false
+	}
 }
 
 func (e *engine) registerWs() {
 	for _, ws := range e.WsHandlers {
-		/*
-			Request route
-		*/
 		route := strings.TrimRight(ws.Path, "/")
-		handlers := make([]gin.HandlerFunc, 0)
-
-		/*
-			Middlewares
-		*/
-		for key, mid := range e.Middlewares {
-			var skip = false
-			for i := range mid.SkipPaths {
-				if match, _ := regexp.Match(mid.SkipPaths[i], []byte(route)); match {
-					skip = true
-					break
-				}
-			}
-			if skip {
-				break
-			}
-
-			var match bool = false
-			for i := range mid.MatchPaths {
-				if match, _ = regexp.Match(mid.MatchPaths[i], []byte(route)); match {
-					break
-				}
-			}
-			if !match {
-				continue
-			}
-
-			handlers = append([]gin.HandlerFunc{e.Middlewares[key].Handler}, handlers...)
-		}
-
-		e.Gin.GET(route, handlers...)
+		e.Gin.GET(route, e.wsChain(ws).handlers...)
 	}
 }
 
 func (e *engine) registerApis() {
 	for _, api := range e.ApiHandlers {
-
-		/*
-			Request route
-		*/
 		route := strings.TrimRight(api.Path, "/")
-		handlers := make([]gin.HandlerFunc, 0)
-
-		/*
-			Rate limit
-		*/
-		if api.Opts != nil && api.Opts.RateLimit != nil {
-			handlers = append([]gin.HandlerFunc{
-				_gin.NewMiddleware(limiter.New(memory.NewStore(), limiter.Rate{Period: api.Opts.RateLimit.Duration, Limit: api.Opts.RateLimit.Rate})),
-			}, handlers...)
-		}
-
-		/*
-			Middlewares
-		*/
-		for key, mid := range e.Middlewares {
-			var skip = false
-			for i := range mid.SkipPaths {
-				if match, _ := regexp.Match(mid.SkipPaths[i], []byte(route)); match {
-					skip = true
-					break
-				}
-			}
-			if skip {
-				break
-			}
-
-			var match bool = false
-			for i := range mid.MatchPaths {
-				if match, _ = regexp.Match(mid.MatchPaths[i], []byte(route)); match {
-					break
-				}
-			}
-			if !match {
-				continue
-			}
-
-			handlers = append([]gin.HandlerFunc{e.Middlewares[key].Handler}, handlers...)
-		}
-
-		/*
-			Cache
-		*/
-		if api.Opts != nil && api.Opts.Cache != nil {
-			handlers = append(handlers, cache.CachePage(persistence.NewInMemoryStore(time.Second), api.Opts.Cache.Duration, api.Handler))
-		} else {
-			handlers = append(handlers, api.Handler)
-		}
+		handlers := e.apiChain(api).handlers
 
 		/*
 			Methods
@@ -0,0 +1,178 @@
+package ginger
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+/*
+FilterOpt whitelists a field of ctx.Filter() and its operators.
+*/
+type FilterOpt struct {
+	// Field is the name used in the query, e.g. filter[status]=active.
+	Field string `json:"field"`
+	// Column is the column of the field, the field by default.
+	Column string `json:"column"`
+	// Operators are the FILTER_OP_* allowed, FILTER_OP_EQ by default.
+	Operators []string `json:"operators"`
+}
+
+type FilterCondition struct {
+	Field    string `json:"field"`
+	Column   string `json:"column"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+/*
+Filter is the parsed filter query of a request.
+*/
+type Filter struct {
+	Conditions []FilterCondition `json:"conditions"`
+}
+
+var filterKeyRegex = regexp.MustCompile(`^filter\[([A-Za-z0-9_.]+)\](?:\[([a-z]+)\])?$`)
+
+/*
+parseFilter parses the filter query against the whitelist:
+
+	filter[status]=active
+	filter[created_at][gte]=2024-01-01
+	filter[id][in]=1,2,3
+	filter[deleted_at][null]=true
+*/
+func parseFilter(query map[string][]string, opts []FilterOpt) (*Filter, error) {
+	filter := &Filter{Conditions: make([]FilterCondition, 0)}
+	for key, values := range query {
+		if !strings.HasPrefix(key, "filter[") {
+			continue
+		}
+		match := filterKeyRegex.FindStringSubmatch(key)
+		if match == nil {
+			return nil, fmt.Errorf("invalid filter %s", key)
+		}
+		field, operator := match[1], match[2]
+		if operator == "" {
+			operator = FILTER_OP_EQ
+		}
+
+		idx := slices.IndexFunc(opts, func(opt FilterOpt) bool { return opt.Field == field })
+		if idx < 0 {
+			return nil, fmt.Errorf("filter on %s is not allowed", field)
+		}
+		opt := opts[idx]
+		if !slices.Contains(opt.operators(), operator) {
+			return nil, fmt.Errorf("filter operator %s on %s is not allowed", operator, field)
+		}
+
+		column := opt.Column
+		if column == "" {
+			column = opt.Field
+		}
+		for _, value := range values {
+			if operator == FILTER_OP_NULL && value != "true" && value != "false" {
+				return nil, fmt.Errorf("filter %s must be true or false", key)
+			}
+			filter.Conditions = append(filter.Conditions, FilterCondition{
+				Field:    field,
+				Column:   column,
+				Operator: operator,
+				Value:    value,
+			})
+		}
+	}
+
+	// the query map is unordered, keep the SQL stable
+	slices.SortFunc(filter.Conditions, func(a, b FilterCondition) int {
+		return strings.Compare(a.Field+a.Operator+a.Value, b.Field+b.Operator+b.Value)
+	})
+	return filter, nil
+}
+
+func (opt FilterOpt) operators() []string {
+	if len(opt.Operators) == 0 {
+		return []string{FILTER_OP_EQ}
+	}
+	return opt.Operators
+}
+
+/*
+Apply adds the conditions of the filter to the query.
+The columns are quoted and the values are bound, so they are safe from SQL injection.
+*/
+func (f *Filter) Apply(tx *gorm.DB) *gorm.DB {
+	if f == nil {
+		return tx
+	}
+	for _, cond := range f.Conditions {
+		column := clause.Column{Name: cond.Column}
+		switch cond.Operator {
+		case FILTER_OP_EQ:
+			tx = tx.Where(clause.Eq{Column: column, Value: cond.Value})
+		case FILTER_OP_NEQ:
+			tx = tx.Where(clause.Neq{Column: column, Value: cond.Value})
+		case FILTER_OP_GT:
+			tx = tx.Where(clause.Gt{Column: column, Value: cond.Value})
+		case FILTER_OP_GTE:
+			tx = tx.Where(clause.Gte{Column: column, Value: cond.Value})
+		case FILTER_OP_LT:
+			tx = tx.Where(clause.Lt{Column: column, Value: cond.Value})
+		case FILTER_OP_LTE:
+			tx = tx.Where(clause.Lte{Column: column, Value: cond.Value})
+		case FILTER_OP_LIKE:
+			// the wildcards of the value match themselves, ! is an escape character valid on all the databases
+			tx = tx.Where(clause.Expr{
+				SQL:  "? LIKE ? ESCAPE '!'",
+				Vars: []any{column, "%" + likeEscaper.Replace(cond.Value) + "%"},
+			})
+		case FILTER_OP_IN:
+			values := make([]any, 0)
+			for _, v := range strings.Split(cond.Value, ",") {
+				values = append(values, v)
+			}
+			tx = tx.Where(clause.IN{Column: column, Values: values})
+		case FILTER_OP_NULL:
+			if cond.Value == "true" {
+				tx = tx.Where(clause.Eq{Column: column, Value: nil})
+			} else {
+				tx = tx.Where(clause.Neq{Column: column, Value: nil})
+			}
+		}
+	}
+	return tx
+}
+
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+/*
+filterParamOf returns the OpenAPI parameter of the whitelisted filters, a deep object, e.g. filter[created_at][gte]=.
+*/
+func filterParamOf(filters []FilterOpt) map[string]any {
+	fields := map[string]any{}
+	for _, f := range filters {
+		operators := map[string]any{}
+		for _, op := range f.operators() {
+			switch op {
+			case FILTER_OP_NULL:
+				operators[op] = map[string]any{"type": "boolean"}
+			case FILTER_OP_IN:
+				operators[op] = map[string]any{"type": "string", "description": "comma separated values"}
+			default:
+				operators[op] = map[string]any{"type": "string"}
+			}
+		}
+		fields[f.Field] = map[string]any{"type": "object", "properties": operators}
+	}
+	return map[string]any{
+		"name":    "filter",
+		"in":      "query",
+		"style":   "deepObject",
+		"explode": true,
+		"schema":  map[string]any{"type": "object", "properties": fields},
+	}
+}
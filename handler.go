@@ -1,10 +1,15 @@
 package ginger
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"regexp"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
 )
 
 type ApiHandler struct {
@@ -15,12 +20,14 @@ type ApiHandler struct {
 }
 
 type WsHandler struct {
-	Handler gin.HandlerFunc `json:"-"`
-	Path    string          `json:"path"`
+	Handler     gin.HandlerFunc                `json:"-"`
+	Path        string                         `json:"path"`
+	Middlewares []func(ctx *Context[struct{}]) `json:"-"`
 }
 
 type CornHandler struct {
 	Handler  func() `json:"-"`
+	Name     string `json:"name"`
 	InitExec bool   `json:"init_exec"`
 	Pattern  string `json:"pattern"`
 }
@@ -30,17 +37,99 @@ type InitJobHandler struct {
 	After   bool   `json:"after"`
 }
 
+type DatabaseHandler struct {
+	Name       string             `json:"name"`
+	Type       string             `json:"type"`
+	Opts       *DBOpts            `json:"opts"`
+	Migrate    []any              `json:"migrate"`
+	Migrations []MigrationHandler `json:"migrations"`
+}
+
+type MigrationHandler struct {
+	Up          func(tx *gorm.DB) error `json:"-"`
+	Down        func(tx *gorm.DB) error `json:"-"`
+	Version     string                  `json:"version"`
+	Description string                  `json:"description"`
+}
+
+type SeedHandler struct {
+	Model    any      `json:"-"`
+	Database string   `json:"database"`
+	File     string   `json:"file"`
+	Modes    []string `json:"modes"`
+}
+
+type HealthCheckHandler struct {
+	Handler func(ctx context.Context) error `json:"-"`
+	Name    string                          `json:"name"`
+	Timeout time.Duration                   `json:"timeout"`
+}
+
+type RateLimitHandler struct {
+	Limits     []*RateLimitOpt `json:"limits"`
+	MatchPaths []string        `json:"match_paths"`
+
+	matchRegexps []*regexp.Regexp
+}
+
+type ConcurrencyLimitHandler struct {
+	Max        int      `json:"max"`
+	MatchPaths []string `json:"match_paths"`
+
+	// the slots are shared by the matching routes
+	slots        chan struct{}
+	matchRegexps []*regexp.Regexp
+}
+
 type MiddlewareHandler struct {
 	Handler    gin.HandlerFunc `json:"-"`
+	Name       string          `json:"name"`
+	Priority   int             `json:"priority"`
 	MatchPaths []string        `json:"match_paths"`
 	SkipPaths  []string        `json:"exclude"`
+
+	matchRegexps []*regexp.Regexp
+	skipRegexps  []*regexp.Regexp
 }
 
-func apiToHandler[T any](f func(ctx *Context[T])) gin.HandlerFunc {
+func apiToHandler[T any](f func(ctx *Context[T]), opts *ApiOpts) gin.HandlerFunc {
+	transactional := opts != nil && opts.Transactional
 	return func(ctx *gin.Context) {
 		c := NewContext[T](ctx)
+		c.opts = opts
+
+		if transactional {
+			if err := c.begin(); err != nil {
+				c.LogErr("begin transaction: ", err)
+				c.InternalServerError("internal server error")
+				ctx.JSON(c.status, c.Response)
+				return
+			}
+			defer c.rollback()
+		}
+
 		f(c)
 
+		// the deadline passed or the client went away, the handler's response is discarded
+		if err := c.Context().Err(); err != nil && !c.isFile {
+			c.hasResp = false
+			if errors.Is(err, context.DeadlineExceeded) {
+				c.GatewayTimeout("request timeout")
+			} else {
+				c.ServiceUnavailable("request cancelled")
+			}
+		}
+
+		if transactional {
+			if err := c.commit(); err != nil {
+				c.LogErr("commit transaction: ", err)
+				if !c.isFile {
+					c.hasResp = false
+					c.InternalServerError("internal server error")
+				}
+			}
+		}
+
 		// if file is served, no need to respond
 		if c.hasResp && c.isFile {
 			return
@@ -54,6 +143,12 @@ func apiToHandler[T any](f func(ctx *Context[T])) gin.HandlerFunc {
 			return
 		}
 
+		if c.notModified() {
+			return
+		}
+		if c.Response.Success {
+			ctx.Set(ctx_key_cacheable, true)
+		}
 		ctx.JSON(c.status, c.Response)
 	}
 }
@@ -74,14 +169,31 @@ func wsToHandler[T any](f func(ctx *Context[T], ws *websocket.Conn)) gin.Handler
 		}
 		defer ws.Close()
 
+		route := c.FullPath()
+		Engine.metrics.wsOpened(route)
+		defer Engine.metrics.wsClosed(route)
+
 		ctx := NewContext[T](c)
 		f(ctx, ws)
 	}
 }
 
+/*
+middlewareToHandler converts the middleware, a response of the middleware aborts the chain,
+e.g. ctx.Unauthorized renders the error envelope without calling the handler.
+*/
 func middlewareToHandler(f func(ctx *Context[struct{}])) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		c := NewContext[struct{}](ctx)
 		f(c)
+
+		if !c.hasResp {
+			return
+		}
+		if c.isFile || c.Response == nil {
+			ctx.Abort()
+			return
+		}
+		ctx.AbortWithStatusJSON(c.status, c.Response)
 	}
 }
@@ -0,0 +1,174 @@
+package ginger
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var errHealthCheckPanic = errors.New("health check panicked")
+
+type HealthReport struct {
+	Status string                       `json:"status"`
+	Checks map[string]HealthCheckReport `json:"checks,omitempty"`
+}
+
+type HealthCheckReport struct {
+	Status   string `json:"status"`
+	Duration int64  `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+/*
+SetHealthPaths sets the paths of the liveness and readiness probes, /healthz and /readyz by default,
+e.g. when the application has routes of its own there. An empty path is not registered.
+*/
+func (e *engine) SetHealthPaths(liveness, readiness string) {
+	e.Configs.LivenessPath = liveness
+	e.Configs.ReadinessPath = readiness
+}
+
+func (e *engine) setupHealth() {
+	if e.Configs.LivenessPath != "" {
+		e.Gin.GET(e.Configs.LivenessPath, e.liveness)
+	}
+	if e.Configs.ReadinessPath != "" {
+		e.Gin.GET(e.Configs.ReadinessPath, e.readiness)
+	}
+}
+
+/*
+liveness reports that the process is up and serving requests.
+*/
+func (e *engine) liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, HealthReport{Status: HEALTH_STATUS_UP})
+}
+
+/*
+readiness reports whether the application can accept traffic.
+It is not ready before the after init jobs are done, during the shutdown
+or when any of the health checks fails.
+*/
+func (e *engine) readiness(c *gin.Context) {
+	report := HealthReport{
+		Status: HEALTH_STATUS_READY,
+		Checks: e.runHealthChecks(c.Request.Context()),
+	}
+	for _, check := range report.Checks {
+		if check.Status != HEALTH_STATUS_UP {
+			report.Status = HEALTH_STATUS_NOT_READY
+		}
+	}
+	if !e.ready.Load() {
+		report.Status = HEALTH_STATUS_NOT_READY
+	}
+
+	if report.Status != HEALTH_STATUS_READY {
+		c.JSON(http.StatusServiceUnavailable, report)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func (e *engine) hasHealthCheck(name string) bool {
+	for _, check := range e.HealthChecks {
+		if check.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+healthChecks returns the built-in database checks followed by the registered ones.
+*/
+func (e *engine) healthChecks() []HealthCheckHandler {
+	checks := make([]HealthCheckHandler, 0)
+	if e.DB != nil {
+		checks = append(checks, HealthCheckHandler{Name: "db", Handler: pingDB(e.DB)})
+	}
+	if e.MEM != nil {
+		checks = append(checks, HealthCheckHandler{Name: "mem", Handler: pingDB(e.MEM)})
+	}
+	for _, handler := range e.Databases {
+		if db := e.databases[handler.Name]; db != nil {
+			checks = append(checks, HealthCheckHandler{Name: handler.Name, Handler: pingDB(db)})
+		}
+	}
+	return append(checks, e.HealthChecks...)
+}
+
+/*
+runHealthChecks runs all the checks concurrently, each bounded by its own timeout.
+*/
+func (e *engine) runHealthChecks(ctx context.Context) map[string]HealthCheckReport {
+	checks := e.healthChecks()
+	reports := make(map[string]HealthCheckReport, len(checks))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check HealthCheckHandler) {
+			defer wg.Done()
+			report := runHealthCheck(ctx, check)
+			mu.Lock()
+			reports[check.Name] = report
+			mu.Unlock()
+		}(check)
+	}
+	wg.Wait()
+	return reports
+}
+
+func runHealthCheck(ctx context.Context, check HealthCheckHandler) HealthCheckReport {
+	timeout := check.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_HEALTH_CHECK_TIMEOUT
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	startAt := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- errHealthCheckPanic
+			}
+		}()
+		done <- check.Handler(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	report := HealthCheckReport{
+		Status:   HEALTH_STATUS_UP,
+		Duration: time.Since(startAt).Milliseconds(),
+	}
+	if err != nil {
+		report.Status = HEALTH_STATUS_DOWN
+		report.Error = err.Error()
+	}
+	return report
+}
+
+func pingDB(db *gorm.DB) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.PingContext(ctx)
+	}
+}
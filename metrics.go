@@ -0,0 +1,208 @@
+package ginger
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+)
+
+/*
+metrics holds the prometheus collectors of the engine.
+A nil *metrics is valid and records nothing, so the callers
+do not need to check whether the metrics are enabled.
+*/
+type metrics struct {
+	registry *prometheus.Registry
+
+	requests         *prometheus.CounterVec
+	requestDuration  *prometheus.HistogramVec
+	requestsInFlight prometheus.Gauge
+	wsConnections    *prometheus.GaugeVec
+	rateLimited      *prometheus.CounterVec
+	requestsShed     *prometheus.CounterVec
+	cacheHits        *prometheus.CounterVec
+	cacheMisses      *prometheus.CounterVec
+	cronRuns         *prometheus.CounterVec
+	cronFailures     *prometheus.CounterVec
+	cronDuration     *prometheus.HistogramVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ginger_http_requests_total",
+			Help: "Total number of HTTP requests by method, route and status.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "ginger_http_request_duration_seconds",
+			Help:    "HTTP request latency by method and route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		requestsInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ginger_http_requests_in_flight",
+			Help: "Number of HTTP requests currently being served.",
+		}),
+		wsConnections: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ginger_ws_connections",
+			Help: "Number of open WebSocket connections by route.",
+		}, []string{"route"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ginger_rate_limit_rejections_total",
+			Help: "Total number of requests rejected by the rate limiter by method and route.",
+		}, []string{"method", "route"}),
+		requestsShed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ginger_requests_shed_total",
+			Help: "Total number of requests rejected by the concurrency limits and the load shedding by method, route and reason.",
+		}, []string{"method", "route", "reason"}),
+		cacheHits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ginger_cache_hits_total",
+			Help: "Total number of response cache hits by method and route.",
+		}, []string{"method", "route"}),
+		cacheMisses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ginger_cache_misses_total",
+			Help: "Total number of response cache misses by method and route.",
+		}, []string{"method", "route"}),
+		cronRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ginger_cron_runs_total",
+			Help: "Total number of cron job runs by job and pattern.",
+		}, []string{"job", "pattern"}),
+		cronFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ginger_cron_failures_total",
+			Help: "Total number of cron job runs that panicked by job and pattern.",
+		}, []string{"job", "pattern"}),
+		cronDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "ginger_cron_duration_seconds",
+			Help:    "Cron job run duration by job and pattern.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"job", "pattern"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.requestsInFlight,
+		m.wsConnections,
+		m.rateLimited,
+		m.requestsShed,
+		m.cacheHits,
+		m.cacheMisses,
+		m.cronRuns,
+		m.cronFailures,
+		m.cronDuration,
+	)
+	return m
+}
+
+/*
+registerDB exposes the connection pool stats of the given database.
+*/
+func (m *metrics) registerDB(name string, db *gorm.DB) {
+	if db == nil {
+		return
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return
+	}
+	m.registry.MustRegister(collectors.NewDBStatsCollector(sqlDB, name))
+}
+
+/*
+handler returns the gin handler serving the metrics in prometheus text format.
+*/
+func (m *metrics) handler() gin.HandlerFunc {
+	h := promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	return gin.WrapH(h)
+}
+
+/*
+middleware records the request count, latency and in-flight requests.
+The route label is the route template, e.g. /users/:id.
+*/
+func (m *metrics) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startAt := time.Now()
+		m.requestsInFlight.Inc()
+		defer func() {
+			m.requestsInFlight.Dec()
+
+			// the recovery is the outer middleware, a panic is recorded as 500 before being rethrown
+			status := c.Writer.Status()
+			r := recover()
+			if r != nil {
+				status = http.StatusInternalServerError
+			}
+
+			route := c.FullPath()
+			m.requests.WithLabelValues(c.Request.Method, route, strconv.Itoa(status)).Inc()
+			m.requestDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(startAt).Seconds())
+
+			if r != nil {
+				panic(r)
+			}
+		}()
+
+		c.Next()
+	}
+}
+
+func (m *metrics) wsOpened(route string) {
+	if m == nil {
+		return
+	}
+	m.wsConnections.WithLabelValues(route).Inc()
+}
+
+func (m *metrics) wsClosed(route string) {
+	if m == nil {
+		return
+	}
+	m.wsConnections.WithLabelValues(route).Dec()
+}
+
+func (m *metrics) rateLimitRejected(method, route string) {
+	if m == nil {
+		return
+	}
+	m.rateLimited.WithLabelValues(method, route).Inc()
+}
+
+func (m *metrics) requestShed(method, route, reason string) {
+	if m == nil {
+		return
+	}
+	m.requestsShed.WithLabelValues(method, route, reason).Inc()
+}
+
+func (m *metrics) cacheHit(method, route string) {
+	if m == nil {
+		return
+	}
+	m.cacheHits.WithLabelValues(method, route).Inc()
+}
+
+func (m *metrics) cacheMiss(method, route string) {
+	if m == nil {
+		return
+	}
+	m.cacheMisses.WithLabelValues(method, route).Inc()
+}
+
+func (m *metrics) cronRun(job, pattern string, duration time.Duration, failed bool) {
+	if m == nil {
+		return
+	}
+	m.cronRuns.WithLabelValues(job, pattern).Inc()
+	m.cronDuration.WithLabelValues(job, pattern).Observe(duration.Seconds())
+	if failed {
+		m.cronFailures.WithLabelValues(job, pattern).Inc()
+	}
+}
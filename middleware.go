@@ -0,0 +1,197 @@
+package ginger
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+RouteInfo is a registered route with the names of its handler chain in execution order,
+after the engine-wide recovery, cors and metrics middlewares.
+*/
+type RouteInfo struct {
+	Method string   `json:"method"`
+	Path   string   `json:"path"`
+	Chain  []string `json:"chain"`
+}
+
+/*
+Routes returns the api and websocket routes with their effective handler chains, e.g. to check
+which middlewares apply to a route.
+*/
+func (e *engine) Routes() []RouteInfo {
+	routes := make([]RouteInfo, 0, len(e.ApiHandlers)+len(e.WsHandlers))
+	for _, api := range e.ApiHandlers {
+		routes = append(routes, RouteInfo{
+			Method: api.Method,
+			Path:   strings.TrimRight(api.Path, "/"),
+			Chain:  e.apiChain(api).names,
+		})
+	}
+	for _, ws := range e.WsHandlers {
+		routes = append(routes, RouteInfo{
+			Method: http.MethodGet,
+			Path:   strings.TrimRight(ws.Path, "/"),
+			Chain:  e.wsChain(ws).names,
+		})
+	}
+	return routes
+}
+
+/*
+handlerChain is the handler chain of a route with the name of every handler.
+*/
+type handlerChain struct {
+	handlers []gin.HandlerFunc
+	names    []string
+}
+
+func (c *handlerChain) add(name string, handlers ...gin.HandlerFunc) {
+	for _, handler := range handlers {
+		c.handlers = append(c.handlers, handler)
+		c.names = append(c.names, name)
+	}
+}
+
+/*
+apiChain returns the handler chain of the api: the load shedding, the timeout, the authentication,
+the global middlewares then the ones of the route, the rate limits and the concurrency limits,
+then the handler behind the cache.
+*/
+func (e *engine) apiChain(api ApiHandler) *handlerChain {
+	route := strings.TrimRight(api.Path, "/")
+	chain := new(handlerChain)
+
+	if e.Configs.MaxInFlight > 0 {
+		chain.add("load_shedding", e.loadShedding(api.Method, route))
+	}
+
+	timeout := e.Configs.Timeout
+	if api.Opts != nil && api.Opts.Timeout > 0 {
+		timeout = api.Opts.Timeout
+	}
+	if timeout > 0 {
+		chain.add("timeout", timeoutToHandler(timeout))
+	}
+
+	if api.Opts != nil && api.Opts.Auth {
+		chain.add("auth", e.authenticate())
+	}
+
+	for _, mid := range e.middlewaresOf(route) {
+		chain.add(mid.Name, mid.Handler)
+	}
+	if api.Opts != nil {
+		for _, mid := range api.Opts.Middlewares {
+			chain.add(funcName(mid), middlewareToHandler(mid))
+		}
+	}
+
+	chain.add("rate_limit", e.groupRateLimits(api.Method, route)...)
+	if limits := rateLimitsOf(api.Opts); len(limits) > 0 {
+		chain.add("rate_limit", e.rateLimit(api.Method, route, api.Method+" "+route, limits))
+	}
+
+	chain.add("concurrency_limit", e.concurrencyLimits(api.Method, route, api.Opts)...)
+
+	if api.Opts != nil && api.Opts.Cache != nil {
+		chain.add("cache", e.cachePage(api.Method, route, api.Opts.Cache, api.Handler))
+	} else {
+		chain.add("handler", api.Handler)
+	}
+	return chain
+}
+
+/*
+wsChain returns the handler chain of the websocket:
+the global middlewares then the ones of the route, and the handler.
+*/
+func (e *engine) wsChain(ws WsHandler) *handlerChain {
+	route := strings.TrimRight(ws.Path, "/")
+	chain := new(handlerChain)
+	for _, mid := range e.middlewaresOf(route) {
+		chain.add(mid.Name, mid.Handler)
+	}
+	for _, mid := range ws.Middlewares {
+		chain.add(funcName(mid), middlewareToHandler(mid))
+	}
+	chain.add("handler", ws.Handler)
+	return chain
+}
+
+/*
+middlewareNames returns the names of the middlewares of the api, the global ones then the ones of the route.
+*/
+func (e *engine) middlewareNames(api ApiHandler) []string {
+	names := make([]string, 0)
+	for _, mid := range e.middlewaresOf(strings.TrimRight(api.Path, "/")) {
+		names = append(names, mid.Name)
+	}
+	if api.Opts != nil {
+		for _, mid := range api.Opts.Middlewares {
+			names = append(names, funcName(mid))
+		}
+	}
+	return names
+}
+
+/*
+middlewaresOf returns the middlewares applying to the route,
+by ascending priority, then in registration order.
+*/
+func (e *engine) middlewaresOf(route string) []MiddlewareHandler {
+	middlewares := make([]MiddlewareHandler, 0, len(e.Middlewares))
+	for _, mid := range e.Middlewares {
+		if mid.applies(route) {
+			middlewares = append(middlewares, mid)
+		}
+	}
+	slices.SortStableFunc(middlewares, func(a, b MiddlewareHandler) int {
+		return a.Priority - b.Priority
+	})
+	return middlewares
+}
+
+/*
+applies reports whether the route matches one of the match paths and none of the skip paths.
+*/
+func (m *MiddlewareHandler) applies(route string) bool {
+	for _, skip := range m.skipRegexps {
+		if skip.MatchString(route) {
+			return false
+		}
+	}
+	for _, match := range m.matchRegexps {
+		if match.MatchString(route) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+compileRegexps compiles the path patterns, panicking on an invalid one at registration.
+*/
+func compileRegexps(patterns []string) []*regexp.Regexp {
+	regexps := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		regexps = append(regexps, regexp.MustCompile(pattern))
+	}
+	return regexps
+}
+
+/*
+funcName returns the name of the function, e.g. main.auth.
+*/
+func funcName(f any) string {
+	if fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer()); fn != nil {
+		return fn.Name()
+	}
+	return "middleware"
+}
@@ -0,0 +1,459 @@
+package ginger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+/*
+SchemaMigration is a row of the schema history table,
+one per applied versioned migration.
+*/
+type SchemaMigration struct {
+	Version     string    `gorm:"primaryKey;size:191" json:"version"`
+	Description string    `json:"description"`
+	AppliedAt   time.Time `json:"applied_at"`
+	Duration    int64     `json:"duration"`
+}
+
+func (SchemaMigration) TableName() string {
+	return "ginger_schema_migrations"
+}
+
+/*
+schemaLock is held by the replica running the migrations,
+so that only one replica migrates at a time.
+*/
+type schemaLock struct {
+	Name     string    `gorm:"primaryKey;size:191"`
+	Owner    string    `gorm:"size:191"`
+	LockedAt time.Time `gorm:"index"`
+}
+
+func (schemaLock) TableName() string {
+	return "ginger_schema_locks"
+}
+
+/*
+migrator runs the versioned migrations against one database.
+*/
+type migrator struct {
+	name       string
+	db         *gorm.DB
+	migrations []MigrationHandler
+}
+
+func newMigrator(name string, db *gorm.DB, migrations []MigrationHandler) *migrator {
+	sorted := make([]MigrationHandler, len(migrations))
+	copy(sorted, migrations)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	return &migrator{
+		name:       name,
+		db:         db,
+		migrations: sorted,
+	}
+}
+
+/*
+applied returns the schema history ordered by version.
+It only reads the history, without the table no migration is applied yet.
+*/
+func (m *migrator) applied() ([]SchemaMigration, error) {
+	history := make([]SchemaMigration, 0)
+	if !m.db.Migrator().HasTable(&SchemaMigration{}) {
+		return history, nil
+	}
+	if err := m.db.Order("version").Find(&history).Error; err != nil {
+		return nil, fmt.Errorf("migrate %s: read schema history: %w", m.name, err)
+	}
+	return history, nil
+}
+
+/*
+pending returns the registered migrations not in the schema history yet.
+*/
+func (m *migrator) pending() ([]MigrationHandler, error) {
+	history, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[string]bool, len(history))
+	for _, h := range history {
+		done[h.Version] = true
+	}
+	output := make([]MigrationHandler, 0)
+	for _, mig := range m.migrations {
+		if !done[mig.Version] {
+			output = append(output, mig)
+		}
+	}
+	return output, nil
+}
+
+/*
+Up applies all the pending migrations in version order.
+With dryRun, it only returns the versions that would be applied.
+*/
+func (m *migrator) Up(dryRun bool) ([]string, error) {
+	if len(m.migrations) == 0 {
+		return nil, nil
+	}
+
+	if !dryRun {
+		unlock, err := m.lock()
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+
+	pending, err := m.pending()
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]string, 0)
+	for _, mig := range pending {
+		if !dryRun {
+			if err := m.apply(mig); err != nil {
+				return versions, err
+			}
+		}
+		versions = append(versions, mig.Version)
+	}
+	return versions, nil
+}
+
+/*
+Down reverts the last applied migrations, at most steps of them.
+With dryRun, it only returns the versions that would be reverted.
+*/
+func (m *migrator) Down(steps int, dryRun bool) ([]string, error) {
+	if !dryRun {
+		unlock, err := m.lock()
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+
+	history, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	registered := make(map[string]MigrationHandler, len(m.migrations))
+	for _, mig := range m.migrations {
+		registered[mig.Version] = mig
+	}
+
+	versions := make([]string, 0)
+	for i := len(history) - 1; i >= 0 && len(versions) < steps; i-- {
+		mig, ok := registered[history[i].Version]
+		if !ok {
+			return versions, fmt.Errorf("migrate %s: %s is applied but not registered", m.name, history[i].Version)
+		}
+		if mig.Down == nil {
+			return versions, fmt.Errorf("migrate %s: %s has no down migration", m.name, mig.Version)
+		}
+		if !dryRun {
+			if err := m.revert(mig); err != nil {
+				return versions, err
+			}
+		}
+		versions = append(versions, mig.Version)
+	}
+	return versions, nil
+}
+
+func (m *migrator) apply(mig MigrationHandler) error {
+	startAt := time.Now()
+	err := m.db.Transaction(func(tx *gorm.DB) error {
+		if err := mig.Up(tx); err != nil {
+			return err
+		}
+		return tx.Create(&SchemaMigration{
+			Version:     mig.Version,
+			Description: mig.Description,
+			AppliedAt:   time.Now(),
+			Duration:    time.Since(startAt).Milliseconds(),
+		}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("migrate %s: %s up: %w", m.name, mig.Version, err)
+	}
+	return nil
+}
+
+func (m *migrator) revert(mig MigrationHandler) error {
+	err := m.db.Transaction(func(tx *gorm.DB) error {
+		if err := mig.Down(tx); err != nil {
+			return err
+		}
+		return tx.Delete(&SchemaMigration{Version: mig.Version}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("migrate %s: %s down: %w", m.name, mig.Version, err)
+	}
+	return nil
+}
+
+/*
+lock acquires the migration lock, waiting up to DEFAULT_MIGRATE_LOCK_TIMEOUT for the other replicas to finish.
+MySQL and PostgreSQL use an advisory lock held by a dedicated connection, released if the replica dies.
+The other databases use a row of the lock table, refreshed while migrating,
+a lock not refreshed for DEFAULT_MIGRATE_LOCK_TIMEOUT is considered stale and taken over.
+*/
+func (m *migrator) lock() (func(), error) {
+	if err := m.db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, fmt.Errorf("migrate %s: create schema history: %w", m.name, err)
+	}
+	switch m.db.Dialector.Name() {
+	case "mysql", "postgres":
+		return m.advisoryLock()
+	default:
+		return m.tableLock()
+	}
+}
+
+/*
+advisoryLock acquires the GET_LOCK of MySQL or the pg_advisory_lock of PostgreSQL on a dedicated connection,
+the lock is released with the connection.
+*/
+func (m *migrator) advisoryLock() (func(), error) {
+	sqlDB, err := m.db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("migrate %s: acquire lock: %w", m.name, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DEFAULT_MIGRATE_LOCK_TIMEOUT+10*time.Second)
+	defer cancel()
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("migrate %s: acquire lock: %w", m.name, err)
+	}
+
+	name := "ginger_migrate_" + m.name
+	var locked sql.NullBool
+	var release string
+	var key any
+	if m.db.Dialector.Name() == "mysql" {
+		release, key = "SELECT RELEASE_LOCK(?)", name
+		err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", key, int(DEFAULT_MIGRATE_LOCK_TIMEOUT.Seconds())).Scan(&locked)
+	} else {
+		hash := fnv.New64a()
+		hash.Write([]byte(name))
+		release, key = "SELECT pg_advisory_unlock($1)", int64(hash.Sum64())
+		deadline := time.Now().Add(DEFAULT_MIGRATE_LOCK_TIMEOUT)
+		for {
+			err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked)
+			if err != nil || locked.Bool || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(time.Second)
+		}
+	}
+	if err == nil && !locked.Bool {
+		err = errors.New("held by another replica")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("migrate %s: acquire lock: %w", m.name, err)
+	}
+
+	return func() {
+		conn.ExecContext(context.Background(), release, key)
+		conn.Close()
+	}, nil
+}
+
+/*
+tableLock acquires the row of the lock table, refreshing its locked_at until unlocked.
+*/
+func (m *migrator) tableLock() (func(), error) {
+	if err := m.db.AutoMigrate(&schemaLock{}); err != nil {
+		return nil, fmt.Errorf("migrate %s: create migration lock: %w", m.name, err)
+	}
+
+	hostname, _ := os.Hostname()
+	lock := &schemaLock{
+		Name:  "migrate",
+		Owner: hostname + ":" + strconv.Itoa(os.Getpid()),
+	}
+
+	deadline := time.Now().Add(DEFAULT_MIGRATE_LOCK_TIMEOUT)
+	for {
+		lock.LockedAt = time.Now()
+		err := m.db.Create(lock).Error
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("migrate %s: acquire lock: %w", m.name, err)
+		}
+		m.db.Where("name = ? AND locked_at < ?", lock.Name, time.Now().Add(-DEFAULT_MIGRATE_LOCK_TIMEOUT)).Delete(&schemaLock{})
+		time.Sleep(time.Second)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(DEFAULT_MIGRATE_LOCK_TIMEOUT / 5)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				m.db.Model(&schemaLock{}).
+					Where("name = ? AND owner = ?", lock.Name, lock.Owner).
+					Update("locked_at", time.Now())
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+		m.db.Where("name = ? AND owner = ?", lock.Name, lock.Owner).Delete(&schemaLock{})
+	}, nil
+}
+
+/*
+Migrate applies the pending versioned migrations of the database.
+With dryRun, it only returns the versions that would be applied.
+*/
+func (e *engine) Migrate(dryRun bool) ([]string, error) {
+	m, err := e.migrator("db")
+	if err != nil {
+		return nil, err
+	}
+	return m.Up(dryRun)
+}
+
+/*
+Rollback reverts the last applied versioned migrations of the database.
+With dryRun, it only returns the versions that would be reverted.
+*/
+func (e *engine) Rollback(steps int, dryRun bool) ([]string, error) {
+	m, err := e.migrator("db")
+	if err != nil {
+		return nil, err
+	}
+	return m.Down(steps, dryRun)
+}
+
+/*
+migrator returns the migrator of the database, "db" or a registered one.
+*/
+func (e *engine) migrator(name string) (*migrator, error) {
+	if name == "db" {
+		if e.DB == nil {
+			return nil, errors.New("migrate db: database is not connected")
+		}
+		return newMigrator(name, e.DB, e.DBMigrations), nil
+	}
+
+	handler := e.databaseHandler(name)
+	if handler == nil {
+		return nil, fmt.Errorf("migrate %s: database is not registered", name)
+	}
+	db := e.Database(name)
+	if db == nil {
+		return nil, fmt.Errorf("migrate %s: database is not connected", name)
+	}
+	return newMigrator(name, db, handler.Migrations), nil
+}
+
+/*
+migrateCommand runs the migrate command line:
+
+	<app> migrate [up|down [steps]|status] [--dry-run] [--db name]
+*/
+func (e *engine) migrateCommand(args []string) error {
+	dryRun := false
+	name := "db"
+	positional := make([]string, 0)
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--dry-run":
+			dryRun = true
+		case "--db":
+			if i+1 >= len(args) {
+				return errors.New("migrate: --db requires a database name")
+			}
+			i++
+			name = args[i]
+		default:
+			positional = append(positional, args[i])
+		}
+	}
+
+	m, err := e.migrator(name)
+	if err != nil {
+		return err
+	}
+
+	action := "up"
+	if len(positional) > 0 {
+		action = positional[0]
+	}
+
+	switch action {
+	case "up":
+		versions, err := m.Up(dryRun)
+		for _, v := range versions {
+			e.LogInfo("migrate ", name, " up: ", v, dryRunSuffix(dryRun))
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(positional) > 1 {
+			n, err := strconv.Atoi(positional[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("migrate: invalid steps %q", positional[1])
+			}
+			steps = n
+		}
+		versions, err := m.Down(steps, dryRun)
+		for _, v := range versions {
+			e.LogInfo("migrate ", name, " down: ", v, dryRunSuffix(dryRun))
+		}
+		return err
+	case "status":
+		pending, err := m.Up(true)
+		if err != nil {
+			return err
+		}
+		history, err := m.applied()
+		if err != nil {
+			return err
+		}
+		for _, h := range history {
+			e.LogInfo("applied: ", h.Version, " ", h.AppliedAt.Format(time.RFC3339))
+		}
+		for _, v := range pending {
+			e.LogInfo("pending: ", v)
+		}
+		return nil
+	default:
+		return fmt.Errorf("migrate: unknown action %q", action)
+	}
+}
+
+func dryRunSuffix(dryRun bool) string {
+	if dryRun {
+		return " (dry run)"
+	}
+	return ""
+}
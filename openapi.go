@@ -0,0 +1,316 @@
+package ginger
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/METADIV-GO/gorm"
+)
+
+/*
+GenerateOpenAPI generates the OpenAPI 3 document of the apis, ./apis/openapi.json by default.
+The models, body and response of the typescript options are used for the schemas.
+*/
+func (e *engine) GenerateOpenAPI(file ...string) error {
+	path := "./apis/openapi.json"
+	if len(file) > 0 && file[0] != "" {
+		path = file[0]
+	}
+
+	doc := OpenAPIService.CreateSpec()
+	bytes, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(path, bytes, os.ModePerm)
+}
+
+var OpenAPIService = new(openAPIService)
+
+var jsonMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+type openAPIService struct{}
+
+func (s *openAPIService) CreateSpec() map[string]any {
+	schemas := map[string]any{}
+	schemas["Response"] = map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"success":     map[string]any{"type": "boolean"},
+			"time":        map[string]any{"type": "string", "format": "date-time"},
+			"trace_id":    map[string]any{"type": "string"},
+			"duration":    map[string]any{"type": "integer"},
+			"pagination":  s.schemaOf(reflect.TypeOf(gorm.Pagination{}), schemas),
+			"cursor":      s.schemaOf(reflect.TypeOf(CursorPagination{}), schemas),
+			"err_message": map[string]any{"type": "string"},
+			"data":        map[string]any{},
+		},
+	}
+	for _, api := range Engine.ApiHandlers {
+		if api.Opts == nil || api.Opts.Typescript == nil {
+			continue
+		}
+		for _, model := range api.Opts.Typescript.Models {
+			s.schemaOf(reflect.TypeOf(model), schemas)
+		}
+		for _, t := range api.Opts.Typescript.Types {
+			schemas[t.Name] = s.typeSchema(t, schemas)
+		}
+	}
+
+	paths := map[string]any{}
+	for _, api := range Engine.ApiHandlers {
+		path, params := s.pathOf(api.Path)
+		item, ok := paths[path].(map[string]any)
+		if !ok {
+			item = map[string]any{}
+			paths[path] = item
+		}
+		item[strings.ToLower(api.Method)] = s.operationOf(api, params, schemas)
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "ginger",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{
+					"type":         "http",
+					"scheme":       "bearer",
+					"bearerFormat": "JWT",
+				},
+			},
+		},
+	}
+}
+
+/*
+pathOf converts the gin path to the OpenAPI path and its path parameters,
+e.g. /users/:id to /users/{id}.
+*/
+func (s *openAPIService) pathOf(path string) (string, []any) {
+	params := make([]any, 0)
+	elements := strings.Split(path, "/")
+	for i := range elements {
+		if strings.HasPrefix(elements[i], ":") || strings.HasPrefix(elements[i], "*") {
+			name := elements[i][1:]
+			elements[i] = "{" + name + "}"
+			params = append(params, map[string]any{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "string"},
+			})
+		}
+	}
+	return strings.Join(elements, "/"), params
+}
+
+func (s *openAPIService) operationOf(api ApiHandler, params []any, schemas map[string]any) map[string]any {
+	op := map[string]any{}
+	ts := new(TypescriptOpt)
+	if api.Opts != nil && api.Opts.Typescript != nil {
+		ts = api.Opts.Typescript
+	}
+	if ts.FunctionName != "" {
+		op["operationId"] = ts.FunctionName
+	}
+	if names := Engine.middlewareNames(api); len(names) > 0 {
+		op["x-middlewares"] = names
+	}
+	if api.Opts != nil && api.Opts.Auth {
+		op["security"] = []any{map[string]any{"bearerAuth": []any{}}}
+	}
+
+	for _, form := range ts.Forms {
+		schema := map[string]any{"type": "string"}
+		if form == "by" && api.Opts.Sort != nil && len(api.Opts.Sort.Fields) > 0 {
+			schema["enum"] = api.Opts.Sort.Fields
+		}
+		params = append(params, map[string]any{
+			"name":   form,
+			"in":     "query",
+			"schema": schema,
+		})
+	}
+	if api.Opts != nil && len(api.Opts.Filters) > 0 {
+		params = append(params, filterParamOf(api.Opts.Filters))
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	if ts.Body != "" && api.Method != http.MethodGet {
+		op["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": s.schemaByName(ts.Body, schemas)},
+			},
+		}
+	}
+
+	data := map[string]any{}
+	if ts.Response != "" {
+		data = s.schemaByName(ts.Response, schemas)
+	}
+	op["responses"] = map[string]any{
+		"200": map[string]any{
+			"description": "OK",
+			"content": map[string]any{
+				"application/json": map[string]any{
+					"schema": map[string]any{
+						"allOf": []any{
+							map[string]any{"$ref": "#/components/schemas/Response"},
+							map[string]any{"type": "object", "properties": map[string]any{"data": data}},
+						},
+					},
+				},
+			},
+		},
+		"default": map[string]any{
+			"description": "Error",
+			"content": map[string]any{
+				"application/json": map[string]any{
+					"schema": map[string]any{"$ref": "#/components/schemas/Response"},
+				},
+			},
+		},
+	}
+	return op
+}
+
+/*
+typeSchema returns the schema of the fields picked from the model, all of them required unless optional.
+*/
+func (s *openAPIService) typeSchema(t TypescriptType, schemas map[string]any) map[string]any {
+	model := reflect.TypeOf(t.Model)
+	for model.Kind() == reflect.Pointer {
+		model = model.Elem()
+	}
+	all := map[string]any{}
+	s.addProperties(model, all, schemas)
+
+	properties := map[string]any{}
+	for _, f := range t.Fields {
+		if property, ok := all[f]; ok {
+			properties[f] = property
+		}
+	}
+	schema := map[string]any{"type": "object", "properties": properties}
+	if !t.Optional && len(t.Fields) > 0 {
+		schema["required"] = t.Fields
+	}
+	return schema
+}
+
+/*
+schemaByName returns the schema of a typescript type name, e.g. User[] or string.
+*/
+func (s *openAPIService) schemaByName(name string, schemas map[string]any) map[string]any {
+	if strings.HasSuffix(name, "[]") {
+		return map[string]any{"type": "array", "items": s.schemaByName(strings.TrimSuffix(name, "[]"), schemas)}
+	}
+	switch name {
+	case "string", "number", "boolean":
+		return map[string]any{"type": name}
+	case "any", "void":
+		return map[string]any{}
+	}
+	if _, ok := schemas[name]; !ok {
+		return map[string]any{}
+	}
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+/*
+schemaOf returns the schema of the type, registering the named structs in the components.
+*/
+func (s *openAPIService) schemaOf(t reflect.Type, schemas map[string]any) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	// custom json encoding, e.g. gorm.DeletedAt, the fields do not tell the shape
+	if t.Implements(jsonMarshaler) || reflect.PointerTo(t).Implements(jsonMarshaler) {
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": s.schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.structSchema(t, schemas)
+		}
+		if _, ok := schemas[t.Name()]; !ok {
+			// placeholder first, the struct may refer to itself
+			schemas[t.Name()] = map[string]any{}
+			schemas[t.Name()] = s.structSchema(t, schemas)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	default:
+		return map[string]any{}
+	}
+}
+
+func (s *openAPIService) structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	properties := map[string]any{}
+	s.addProperties(t, properties, schemas)
+	return map[string]any{"type": "object", "properties": properties}
+}
+
+func (s *openAPIService) addProperties(t reflect.Type, properties map[string]any, schemas map[string]any) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				s.addProperties(ft, properties, schemas)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = s.schemaOf(field.Type, schemas)
+	}
+}
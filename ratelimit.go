@@ -0,0 +1,411 @@
+package ginger
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gomodule/redigo/redis"
+	"github.com/ulule/limiter/v3"
+	"github.com/ulule/limiter/v3/drivers/store/common"
+	"github.com/ulule/limiter/v3/drivers/store/memory"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+/*
+SetRateLimitStore sets the default store of the rate limits, overridden by RateLimitOpt.Store.
+A shared store, e.g. NewRedisRateLimitStore, makes the limits global across the replicas.
+The default store is in memory, per process.
+*/
+func (e *engine) SetRateLimitStore(store limiter.Store) {
+	e.rateLimitStore = store
+}
+
+/*
+rateLimitStoreOf returns the store of the rate limit, creating the memory store on first use.
+*/
+func (e *engine) rateLimitStoreOf(opt *RateLimitOpt) limiter.Store {
+	if opt.Store != nil {
+		return opt.Store
+	}
+	if e.rateLimitStore == nil {
+		e.rateLimitStore = memory.NewStoreWithOptions(limiter.StoreOptions{
+			Prefix:          "ginger_rate_limit",
+			CleanUpInterval: limiter.DefaultCleanUpInterval,
+		})
+	}
+	return e.rateLimitStore
+}
+
+/*
+RateLimitKeyFunc returns the key of the client the requests are counted for.
+*/
+type RateLimitKeyFunc func(c *gin.Context) string
+
+/*
+RateLimitByIP counts the requests per client IP, the default.
+*/
+func RateLimitByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+/*
+RateLimitByBearerToken counts the requests per bearer token, per client IP without one.
+The token is hashed, so that it is not stored as is.
+*/
+func RateLimitByBearerToken(c *gin.Context) string {
+	token := bearerTokenOf(c.GetHeader(HEADER_AUTHORIZATION))
+	if token == "" {
+		return RateLimitByIP(c)
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "token:" + hex.EncodeToString(sum[:])
+}
+
+/*
+RateLimitByHeader counts the requests per value of the header, e.g. an API key,
+per client IP without one.
+*/
+func RateLimitByHeader(header string) RateLimitKeyFunc {
+	return func(c *gin.Context) string {
+		value := c.GetHeader(header)
+		if value == "" {
+			return RateLimitByIP(c)
+		}
+		sum := sha256.Sum256([]byte(value))
+		return "header:" + header + ":" + hex.EncodeToString(sum[:])
+	}
+}
+
+/*
+RateLimitByValue counts the requests per value set on the gin context by a middleware,
+e.g. the user or tenant id, per client IP without one.
+*/
+func RateLimitByValue(key string) RateLimitKeyFunc {
+	return func(c *gin.Context) string {
+		value, ok := c.Get(key)
+		if !ok || value == nil || fmt.Sprint(value) == "" {
+			return RateLimitByIP(c)
+		}
+		return "value:" + key + ":" + fmt.Sprint(value)
+	}
+}
+
+/*
+groupRateLimits returns the middlewares of the engine-wide and path-pattern rate limits
+matching the route, their counters are shared by all the matching routes.
+*/
+func (e *engine) groupRateLimits(method, route string) []gin.HandlerFunc {
+	handlers := make([]gin.HandlerFunc, 0)
+	for i, group := range e.RateLimits {
+		if !pathMatches(group.matchRegexps, route) || len(group.Limits) == 0 {
+			continue
+		}
+		handlers = append(handlers, e.rateLimit(method, route, "group"+strconv.Itoa(i), group.Limits))
+	}
+	return handlers
+}
+
+/*
+rateLimitsOf returns the rate limits of the route, RateLimit followed by RateLimits.
+*/
+func rateLimitsOf(opts *ApiOpts) []*RateLimitOpt {
+	if opts == nil {
+		return nil
+	}
+	limits := make([]*RateLimitOpt, 0, len(opts.RateLimits)+1)
+	if opts.RateLimit.valid() {
+		limits = append(limits, opts.RateLimit)
+	}
+	for _, limit := range opts.RateLimits {
+		if limit.valid() {
+			limits = append(limits, limit)
+		}
+	}
+	return limits
+}
+
+/*
+valid reports whether the limit is set with a positive rate and duration.
+*/
+func (opt *RateLimitOpt) valid() bool {
+	return opt != nil && opt.Rate > 0 && opt.Duration > 0
+}
+
+/*
+rateLimit returns the middleware limiting the requests of each client on the route.
+Every limit is counted, the request is rejected when any of them is reached.
+The store may be shared, so the keys are prefixed with the scope of the limits,
+the route, or the group for the limits shared by the matching routes.
+A store failure lets the request through rather than failing the api.
+*/
+func (e *engine) rateLimit(method, route, scope string, opts []*RateLimitOpt) gin.HandlerFunc {
+	limiters := make([]*limiter.Limiter, len(opts))
+	for i, opt := range opts {
+		limiters[i] = limiter.New(e.rateLimitStoreOf(opt), limiter.Rate{Period: opt.Duration, Limit: opt.Rate})
+	}
+
+	return func(c *gin.Context) {
+		var result *limiter.Context
+		for i, opt := range opts {
+			keyOf := opt.Key
+			if keyOf == nil {
+				keyOf = RateLimitByIP
+			}
+			key := scope + "#" + strconv.Itoa(i) + ":" + keyOf(c)
+
+			lc, err := limiters[i].Get(c, key)
+			if err != nil {
+				e.LogErr("rate limit ", method, " ", route, ": ", err)
+				continue
+			}
+			// the headers show the limit the client is the closest to, or the longest to wait for
+			if result == nil ||
+				(lc.Reached && (!result.Reached || lc.Reset > result.Reset)) ||
+				(!lc.Reached && !result.Reached && lc.Remaining < result.Remaining) {
+				result = &lc
+			}
+		}
+		if result == nil {
+			c.Next()
+			return
+		}
+
+		c.Header(HEADER_X_RATE_LIMIT_LIMIT, strconv.FormatInt(result.Limit, 10))
+		c.Header(HEADER_X_RATE_LIMIT_REMAINING, strconv.FormatInt(result.Remaining, 10))
+		c.Header(HEADER_X_RATE_LIMIT_RESET, strconv.FormatInt(result.Reset, 10))
+		if !result.Reached {
+			c.Next()
+			return
+		}
+
+		e.metrics.rateLimitRejected(method, route)
+		c.Header(HEADER_RETRY_AFTER, strconv.FormatInt(max(result.Reset-time.Now().Unix(), 1), 10))
+		abortWithErr(c, http.StatusTooManyRequests, "too many requests")
+	}
+}
+
+/*
+NewRedisRateLimitStore returns a rate limit store on a Redis-compatible server,
+shared by all the replicas connected to it.
+*/
+func NewRedisRateLimitStore(opts *RedisOpts) (limiter.Store, error) {
+	pool, err := newRedisPool(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &redisRateLimitStore{pool: pool, prefix: "ginger_rate_limit:"}, nil
+}
+
+type redisRateLimitStore struct {
+	pool   *redis.Pool
+	prefix string
+}
+
+/*
+redisIncrementScript increments the counter and starts its window atomically,
+returning the count and the milliseconds left in the window.
+*/
+var redisIncrementScript = redis.NewScript(1, `
+local count = redis.call('INCRBY', KEYS[1], ARGV[1])
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	ttl = tonumber(ARGV[2])
+end
+return {count, ttl}
+`)
+
+var redisPeekScript = redis.NewScript(1, `
+local count = tonumber(redis.call('GET', KEYS[1]) or '0')
+local ttl = redis.call('PTTL', KEYS[1])
+return {count, ttl}
+`)
+
+func (s *redisRateLimitStore) Get(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	return s.Increment(ctx, key, 1, rate)
+}
+
+func (s *redisRateLimitStore) Increment(ctx context.Context, key string, count int64, rate limiter.Rate) (limiter.Context, error) {
+	return s.run(ctx, redisIncrementScript, key, rate, count, rate.Period.Milliseconds())
+}
+
+func (s *redisRateLimitStore) Peek(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	return s.run(ctx, redisPeekScript, key, rate)
+}
+
+func (s *redisRateLimitStore) Reset(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return limiter.Context{}, err
+	}
+	defer conn.Close()
+	if _, err := redis.DoContext(conn, ctx, "DEL", s.prefix+key); err != nil {
+		return limiter.Context{}, err
+	}
+	now := time.Now()
+	return common.GetContextFromState(now, rate, now.Add(rate.Period), 0), nil
+}
+
+func (s *redisRateLimitStore) run(ctx context.Context, script *redis.Script, key string, rate limiter.Rate, args ...any) (limiter.Context, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return limiter.Context{}, err
+	}
+	defer conn.Close()
+
+	values, err := redis.Int64s(script.DoContext(ctx, conn, append([]any{s.prefix + key}, args...)...))
+	if err != nil {
+		return limiter.Context{}, err
+	}
+	if len(values) != 2 {
+		return limiter.Context{}, errors.New("rate limit: unexpected redis reply")
+	}
+
+	now := time.Now()
+	expiration := now.Add(rate.Period)
+	if values[1] > 0 {
+		expiration = now.Add(time.Duration(values[1]) * time.Millisecond)
+	}
+	return common.GetContextFromState(now, rate, expiration, values[0]), nil
+}
+
+/*
+rateLimitCounter is a row of the rate limit table of NewDatabaseRateLimitStore.
+*/
+type rateLimitCounter struct {
+	Key      string    `gorm:"primaryKey;size:191"`
+	Hits     int64     `gorm:"not null"`
+	ExpireAt time.Time `gorm:"index"`
+}
+
+func (rateLimitCounter) TableName() string {
+	return "ginger_rate_limits"
+}
+
+/*
+NewDatabaseRateLimitStore returns a rate limit store on the database with the name,
+"mem", "db" or one registered with RegisterDB. It is resolved on first use,
+so it can be set before the databases are connected.
+*/
+func NewDatabaseRateLimitStore(name string) limiter.Store {
+	return &gormRateLimitStore{name: name}
+}
+
+type gormRateLimitStore struct {
+	name string
+
+	mu        sync.Mutex
+	migrated  bool
+	cleanedAt atomic.Int64
+}
+
+/*
+db returns the database of the store, creating the table on first use.
+*/
+func (s *gormRateLimitStore) db(ctx context.Context) (*gorm.DB, error) {
+	db, _, _ := Engine.databaseOf(s.name)
+	if db == nil {
+		return nil, errors.New("rate limit: database " + s.name + " is not connected")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.migrated {
+		if err := db.AutoMigrate(&rateLimitCounter{}); err != nil {
+			return nil, err
+		}
+		s.migrated = true
+	}
+	return db.WithContext(ctx), nil
+}
+
+func (s *gormRateLimitStore) Get(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	return s.Increment(ctx, key, 1, rate)
+}
+
+func (s *gormRateLimitStore) Increment(ctx context.Context, key string, count int64, rate limiter.Rate) (limiter.Context, error) {
+	db, err := s.db(ctx)
+	if err != nil {
+		return limiter.Context{}, err
+	}
+	now := time.Now()
+	s.cleanUp(db, now)
+
+	counter := new(rateLimitCounter)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// the window has passed, start a new one
+		err := tx.Where(clause.Eq{Column: clause.Column{Name: "key"}, Value: key}).
+			Where(clause.Lte{Column: clause.Column{Name: "expire_at"}, Value: now}).
+			Delete(&rateLimitCounter{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "key"}},
+			DoUpdates: clause.Assignments(map[string]any{"hits": gorm.Expr("hits + ?", count)}),
+		}).Create(&rateLimitCounter{Key: key, Hits: count, ExpireAt: now.Add(rate.Period)}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where(clause.Eq{Column: clause.Column{Name: "key"}, Value: key}).First(counter).Error
+	})
+	if err != nil {
+		return limiter.Context{}, err
+	}
+	return common.GetContextFromState(now, rate, counter.ExpireAt, counter.Hits), nil
+}
+
+func (s *gormRateLimitStore) Peek(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	db, err := s.db(ctx)
+	if err != nil {
+		return limiter.Context{}, err
+	}
+	now := time.Now()
+	counter := new(rateLimitCounter)
+	err = db.Where(clause.Eq{Column: clause.Column{Name: "key"}, Value: key}).
+		Where(clause.Gt{Column: clause.Column{Name: "expire_at"}, Value: now}).
+		Limit(1).Find(counter).Error
+	if err != nil {
+		return limiter.Context{}, err
+	}
+	if counter.Key == "" {
+		return common.GetContextFromState(now, rate, now.Add(rate.Period), 0), nil
+	}
+	return common.GetContextFromState(now, rate, counter.ExpireAt, counter.Hits), nil
+}
+
+func (s *gormRateLimitStore) Reset(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	db, err := s.db(ctx)
+	if err != nil {
+		return limiter.Context{}, err
+	}
+	err = db.Where(clause.Eq{Column: clause.Column{Name: "key"}, Value: key}).Delete(&rateLimitCounter{}).Error
+	if err != nil {
+		return limiter.Context{}, err
+	}
+	now := time.Now()
+	return common.GetContextFromState(now, rate, now.Add(rate.Period), 0), nil
+}
+
+/*
+cleanUp deletes the expired counters at most once per clean up interval.
+*/
+func (s *gormRateLimitStore) cleanUp(db *gorm.DB, now time.Time) {
+	last := s.cleanedAt.Load()
+	if now.UnixNano()-last < int64(limiter.DefaultCleanUpInterval) {
+		return
+	}
+	if !s.cleanedAt.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	db.Where(clause.Lte{Column: clause.Column{Name: "expire_at"}, Value: now}).Delete(&rateLimitCounter{})
+}
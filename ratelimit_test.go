@@ -0,0 +1,204 @@
+package ginger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	limiter "github.com/ulule/limiter/v3"
+)
+
+func newTestRedisRateLimitStore(t *testing.T, server *miniredis.Miniredis) limiter.Store {
+	t.Helper()
+	store, err := NewRedisRateLimitStore(&RedisOpts{Addr: server.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+/*
+useTestMEM connects Engine.MEM to a memory database of its own for the test.
+*/
+func useTestMEM(t *testing.T) {
+	t.Helper()
+	db, err := open("test_"+t.Name(), DB_TYPE_MEM, &DBOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := Engine.MEM
+	Engine.MEM = db
+	t.Cleanup(func() {
+		Engine.MEM = previous
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+}
+
+/*
+testRateLimitStore checks Get, Increment, Peek and Reset of the store.
+*/
+func testRateLimitStore(t *testing.T, store limiter.Store) {
+	ctx := context.Background()
+	rate := limiter.Rate{Limit: 5, Period: time.Minute}
+
+	for i := int64(1); i <= 2; i++ {
+		result, err := store.Get(ctx, "client", rate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Remaining != 5-i || result.Reached {
+			t.Fatalf("get %d: remaining %d, reached %v", i, result.Remaining, result.Reached)
+		}
+	}
+
+	result, err := store.Peek(ctx, "client", rate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Remaining != 3 {
+		t.Fatalf("peek: remaining %d, want 3", result.Remaining)
+	}
+	if reset := time.Unix(result.Reset, 0); reset.Before(time.Now()) || reset.After(time.Now().Add(rate.Period+time.Second)) {
+		t.Fatalf("peek: reset %v out of the window", reset)
+	}
+
+	result, err = store.Increment(ctx, "client", 4, rate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Reached || result.Remaining != 0 {
+		t.Fatalf("increment: remaining %d, reached %v", result.Remaining, result.Reached)
+	}
+
+	result, err = store.Peek(ctx, "other", rate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Remaining != 5 {
+		t.Fatalf("peek other: remaining %d, want 5", result.Remaining)
+	}
+
+	if _, err := store.Reset(ctx, "client", rate); err != nil {
+		t.Fatal(err)
+	}
+	result, err = store.Get(ctx, "client", rate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Remaining != 4 {
+		t.Fatalf("get after reset: remaining %d, want 4", result.Remaining)
+	}
+}
+
+/*
+testRateLimitStoreShared checks that two limiters on stores of the same backend share the counts.
+*/
+func testRateLimitStoreShared(t *testing.T, a, b limiter.Store) {
+	ctx := context.Background()
+	rate := limiter.Rate{Limit: 3, Period: time.Minute}
+	replicaA := limiter.New(a, rate)
+	replicaB := limiter.New(b, rate)
+
+	for i := 0; i < 2; i++ {
+		if _, err := replicaA.Get(ctx, "client"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := replicaB.Get(ctx, "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Remaining != 0 || result.Reached {
+		t.Fatalf("replica b: remaining %d, reached %v", result.Remaining, result.Reached)
+	}
+	result, err = replicaA.Get(ctx, "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Reached {
+		t.Fatal("replica a: limit not reached after 4 requests")
+	}
+}
+
+func TestRedisRateLimitStore(t *testing.T) {
+	testRateLimitStore(t, newTestRedisRateLimitStore(t, miniredis.RunT(t)))
+}
+
+func TestRedisRateLimitStoreWindowExpiry(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := newTestRedisRateLimitStore(t, server)
+	ctx := context.Background()
+	rate := limiter.Rate{Limit: 2, Period: time.Minute}
+
+	for i := 0; i < 3; i++ {
+		if _, err := store.Get(ctx, "client", rate); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server.FastForward(rate.Period + time.Millisecond)
+
+	result, err := store.Get(ctx, "client", rate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Reached || result.Remaining != 1 {
+		t.Fatalf("new window: remaining %d, reached %v", result.Remaining, result.Reached)
+	}
+}
+
+func TestRedisRateLimitStoreShared(t *testing.T) {
+	server := miniredis.RunT(t)
+	testRateLimitStoreShared(t, newTestRedisRateLimitStore(t, server), newTestRedisRateLimitStore(t, server))
+}
+
+func TestRedisRateLimitStoreUnavailable(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := newTestRedisRateLimitStore(t, server)
+	server.Close()
+
+	if _, err := store.Get(context.Background(), "client", limiter.Rate{Limit: 1, Period: time.Minute}); err == nil {
+		t.Fatal("get: no error with the server down")
+	}
+}
+
+func TestDatabaseRateLimitStore(t *testing.T) {
+	useTestMEM(t)
+	testRateLimitStore(t, NewDatabaseRateLimitStore("mem"))
+}
+
+func TestDatabaseRateLimitStoreWindowExpiry(t *testing.T) {
+	useTestMEM(t)
+	store := NewDatabaseRateLimitStore("mem")
+	ctx := context.Background()
+	rate := limiter.Rate{Limit: 2, Period: 50 * time.Millisecond}
+
+	for i := 0; i < 3; i++ {
+		if _, err := store.Get(ctx, "client", rate); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(rate.Period + 10*time.Millisecond)
+
+	result, err := store.Peek(ctx, "client", rate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Remaining != 2 {
+		t.Fatalf("peek new window: remaining %d, want 2", result.Remaining)
+	}
+	result, err = store.Get(ctx, "client", rate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Reached || result.Remaining != 1 {
+		t.Fatalf("new window: remaining %d, reached %v", result.Remaining, result.Reached)
+	}
+}
+
+func TestDatabaseRateLimitStoreShared(t *testing.T) {
+	useTestMEM(t)
+	testRateLimitStoreShared(t, NewDatabaseRateLimitStore("mem"), NewDatabaseRateLimitStore("mem"))
+}
@@ -0,0 +1,68 @@
+package ginger
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/METADIV-GO/ginger/pkg/logger"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+ErrorReporter receives the panics recovered from the handlers,
+e.g. to forward them to an error tracking service.
+*/
+type ErrorReporter func(c *gin.Context, traceId string, recovered any, stack []byte)
+
+/*
+recovery converts a panic into the standard response envelope with a
+generic message, and logs the stack trace with the trace id.
+*/
+func (e *engine) recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startAt := time.Now()
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			stack := debug.Stack()
+			// rethrown by the timeout from the goroutine of the handler
+			if p, ok := r.(*handlerPanic); ok {
+				r, stack = p.value, p.stack
+			}
+			traceId := traceIdOf(c)
+
+			logger.ERROR(fmt.Sprintf("trace_id: %s ip: %s agent: %s ", traceId, c.ClientIP(), c.Request.UserAgent()),
+				"panic: ", r, "\n", string(stack))
+
+			if e.errorReporter != nil {
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							logger.ERROR(fmt.Sprintf("trace_id: %s ", traceId), "error reporter panic: ", r)
+						}
+					}()
+					e.errorReporter(c, traceId, r, stack)
+				}()
+			}
+
+			// the response is partially sent, nothing more can be written
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+
+			c.AbortWithStatusJSON(http.StatusInternalServerError, &Response{
+				Success:    false,
+				TraceId:    traceId,
+				Time:       time.Now().Format(time.RFC3339),
+				Duration:   time.Since(startAt).Milliseconds(),
+				ErrMessage: "internal server error",
+			})
+		}()
+		c.Next()
+	}
+}
@@ -0,0 +1,91 @@
+package ginger
+
+import (
+	"context"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+/*
+RedisOpts are the connection options of a Redis-compatible server,
+the empty ones are read from the REDIS_* environment variables.
+*/
+type RedisOpts struct {
+	Addr     string `json:"addr"`
+	Username string `json:"username"`
+	Password string `json:"-"`
+	DB       int    `json:"db"`
+	TLS      bool   `json:"tls"`
+	// MaxIdle is the max number of idle connections, 10 by default.
+	MaxIdle int `json:"max_idle"`
+}
+
+/*
+resolveRedisOpts fills the empty options from the environment.
+*/
+func resolveRedisOpts(opts *RedisOpts) (*RedisOpts, error) {
+	resolved := new(RedisOpts)
+	if opts != nil {
+		*resolved = *opts
+	}
+	if resolved.Addr == "" {
+		resolved.Addr = Env("REDIS_ADDR")
+	}
+	if resolved.Addr == "" {
+		resolved.Addr = "localhost:6379"
+	}
+	if resolved.Username == "" {
+		resolved.Username = Env("REDIS_USERNAME")
+	}
+	if resolved.Password == "" {
+		resolved.Password = Env("REDIS_PASSWORD")
+	}
+	if resolved.DB == 0 {
+		db, err := envInt("REDIS_DB")
+		if err != nil {
+			return nil, err
+		}
+		resolved.DB = db
+	}
+	if !resolved.TLS {
+		resolved.TLS = Env("REDIS_TLS") == "true"
+	}
+	if resolved.MaxIdle == 0 {
+		resolved.MaxIdle = 10
+	}
+	return resolved, nil
+}
+
+/*
+newRedisPool returns the connection pool of the server,
+the connections are dialed on first use.
+*/
+func newRedisPool(opts *RedisOpts) (*redis.Pool, error) {
+	opts, err := resolveRedisOpts(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &redis.Pool{
+		MaxIdle:     opts.MaxIdle,
+		IdleTimeout: 5 * time.Minute,
+		DialContext: func(ctx context.Context) (redis.Conn, error) {
+			options := []redis.DialOption{
+				redis.DialDatabase(opts.DB),
+				redis.DialUseTLS(opts.TLS),
+				redis.DialConnectTimeout(5 * time.Second),
+			}
+			if opts.Password != "" {
+				options = append(options, redis.DialUsername(opts.Username), redis.DialPassword(opts.Password))
+			}
+			return redis.DialContext(ctx, "tcp", opts.Addr, options...)
+		},
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			if time.Since(t) < time.Minute {
+				return nil
+			}
+			_, err := c.Do("PING")
+			return err
+		},
+	}, nil
+}
@@ -0,0 +1,202 @@
+package ginger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+/*
+replicaPool routes the reads to the healthy read replicas in round robin,
+and falls back to the primary when none of them is healthy.
+A nil *replicaPool always returns the primary.
+*/
+type replicaPool struct {
+	engine   *engine
+	dbType   string
+	primary  *gorm.DB
+	replicas []*replica
+	maxLag   time.Duration
+	onGet    bool
+	next     atomic.Uint64
+	done     chan struct{}
+}
+
+type replica struct {
+	db      *gorm.DB
+	healthy atomic.Bool
+}
+
+/*
+setupReplicas connects the read replicas of the database and starts
+watching their health.
+*/
+func (e *engine) setupReplicas(opts *DBOpts) error {
+	if opts == nil || len(opts.Replicas) == 0 || e.DB == nil {
+		return nil
+	}
+
+	pool := &replicaPool{
+		engine:   e,
+		dbType:   e.Configs.DBType,
+		primary:  e.DB,
+		replicas: make([]*replica, 0, len(opts.Replicas)),
+		maxLag:   opts.MaxReplicaLag,
+		onGet:    opts.ReplicaOnGet,
+		done:     make(chan struct{}),
+	}
+	for i, dsn := range opts.Replicas {
+		replicaOpts := *opts
+		replicaOpts.DSN = dsn
+		db, err := e.retryOpen("db_replica_"+strconv.Itoa(i), e.Configs.DBType, &replicaOpts)
+		if err != nil {
+			return err
+		}
+		pool.replicas = append(pool.replicas, &replica{db: db})
+	}
+
+	pool.check()
+	go pool.watch(DEFAULT_REPLICA_CHECK)
+	e.dbReplicas = pool
+	return nil
+}
+
+/*
+DBRead returns a read replica of the database, or the database itself
+when there is no healthy replica.
+*/
+func (e *engine) DBRead() *gorm.DB {
+	if e.dbReplicas == nil {
+		return e.DB
+	}
+	return e.dbReplicas.pick()
+}
+
+func (p *replicaPool) pick() *gorm.DB {
+	n := uint64(len(p.replicas))
+	for i := uint64(0); i < n; i++ {
+		r := p.replicas[(p.next.Add(1)-1)%n]
+		if r.healthy.Load() {
+			return r.db
+		}
+	}
+	return p.primary
+}
+
+func (p *replicaPool) dbs() []*gorm.DB {
+	if p == nil {
+		return nil
+	}
+	output := make([]*gorm.DB, 0, len(p.replicas))
+	for _, r := range p.replicas {
+		output = append(output, r.db)
+	}
+	return output
+}
+
+func (p *replicaPool) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.check()
+		}
+	}
+}
+
+func (p *replicaPool) stop() {
+	if p == nil {
+		return
+	}
+	close(p.done)
+}
+
+/*
+check pings the replicas and measures their lag, taking the failing
+or lagging ones out of the rotation.
+*/
+func (p *replicaPool) check() {
+	for i, r := range p.replicas {
+		err := p.checkOne(r.db)
+		if err != nil && r.healthy.Load() {
+			p.engine.LogErr("db replica ", i, " is out of rotation: ", err)
+		}
+		r.healthy.Store(err == nil)
+	}
+}
+
+func (p *replicaPool) checkOne(db *gorm.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DEFAULT_HEALTH_CHECK_TIMEOUT)
+	defer cancel()
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return err
+	}
+	if p.maxLag <= 0 {
+		return nil
+	}
+
+	lag, err := replicaLag(ctx, p.dbType, db)
+	if err != nil {
+		return err
+	}
+	if lag > p.maxLag {
+		return fmt.Errorf("replica lag %s exceeds %s", lag, p.maxLag)
+	}
+	return nil
+}
+
+/*
+replicaLag returns how far the replica is behind the primary.
+MySQL before 8.0.22 only has SHOW SLAVE STATUS, and MariaDB reports Seconds_Behind_Master.
+*/
+func replicaLag(ctx context.Context, dbType string, db *gorm.DB) (time.Duration, error) {
+	switch dbType {
+	case DB_TYPE_MYSQL:
+		status := make(map[string]any)
+		if err := db.WithContext(ctx).Raw("SHOW REPLICA STATUS").Scan(&status).Error; err != nil {
+			if err := db.WithContext(ctx).Raw("SHOW SLAVE STATUS").Scan(&status).Error; err != nil {
+				return 0, err
+			}
+		}
+		seconds, ok := status["Seconds_Behind_Source"]
+		if !ok {
+			seconds, ok = status["Seconds_Behind_Master"]
+		}
+		if !ok || seconds == nil {
+			return 0, errors.New("replication is not running")
+		}
+		text := fmt.Sprint(seconds)
+		if b, ok := seconds.([]byte); ok {
+			text = string(b)
+		}
+		n, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n * float64(time.Second)), nil
+	case DB_TYPE_PGSQL:
+		var seconds sql.NullFloat64
+		err := db.WithContext(ctx).
+			Raw("SELECT EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())").
+			Scan(&seconds).Error
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(seconds.Float64 * float64(time.Second)), nil
+	}
+	return 0, nil
+}
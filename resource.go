@@ -0,0 +1,473 @@
+package ginger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+type ResourceOpts[M any] struct {
+	// Name is the base name of the typescript functions, the model type name by default.
+	Name string
+	// Filters are the json fields the list can be filtered on, e.g. ?filter[status]=active.
+	// The column of a filter is resolved from the model when empty.
+	Filters []FilterOpt
+	// CreateFields are the json fields accepted on create, the create route is not registered when empty.
+	// The primary key, gorm.DeletedAt and the auto create and update time fields cannot be written.
+	CreateFields []string
+	// UpdateFields are the json fields accepted on update, the update route is not registered when empty.
+	UpdateFields []string
+	// HardDelete deletes permanently the models with gorm.DeletedAt instead of soft deleting them.
+	HardDelete bool
+	// Authorize is called before each action, the record is nil on list.
+	// An error responds forbidden with its message.
+	Authorize func(ctx *Context[struct{}], action string, record *M) error
+	// Scope restricts the records visible to the request, e.g. to the user's tenant.
+	// A record created or updated out of the scope is rolled back and forbidden.
+	Scope func(ctx *Context[struct{}], tx *gorm.DB) *gorm.DB
+	// Api is applied to the five routes.
+	Api *ApiOpts
+}
+
+/*
+Resource registers the list, get, create, update and delete routes of the model:
+
+	GET    path
+	GET    path/:id
+	POST   path      with ResourceOpts.CreateFields
+	PUT    path/:id  with ResourceOpts.UpdateFields
+	DELETE path/:id
+
+The typescript body of create is Create<Name>, picking the create fields of the model,
+the one of update is Update<Name>, with the update fields optional.
+It panics when a create or update field is not a writable field of the model.
+*/
+func Resource[M any](path string, opts ...*ResourceOpts[M]) {
+	opt := new(ResourceOpts[M])
+	if len(opts) > 0 && opts[0] != nil {
+		opt = opts[0]
+	}
+	r := &resource[M]{opts: opt}
+
+	path = strings.TrimRight(path, "/")
+	name := opt.Name
+	if name == "" {
+		name = reflect.TypeOf(new(M)).Elem().Name()
+	}
+	if err := checkWritable[M](opt.CreateFields, opt.UpdateFields); err != nil {
+		panic(fmt.Errorf("resource %s: %w", name, err))
+	}
+
+	listOpts := r.apiOpts(&TypescriptOpt{
+		Models:       []any{*new(M)},
+		FunctionName: "list" + name,
+		Forms:        []string{"page", "size", "by", "asc"},
+		Response:     name + "[]",
+	})
+	listOpts.Filters = opt.Filters
+	GET[struct{}](path, r.list, listOpts)
+	GET[struct{}](path+"/:id", r.get, r.apiOpts(&TypescriptOpt{
+		FunctionName: "get" + name,
+		Paths:        []string{"id"},
+		Response:     name,
+	}))
+	if len(opt.CreateFields) > 0 {
+		POST[struct{}](path, r.create, r.apiOpts(&TypescriptOpt{
+			FunctionName: "create" + name,
+			Body:         "Create" + name,
+			Response:     name,
+			Types:        []TypescriptType{{Name: "Create" + name, Model: *new(M), Fields: opt.CreateFields}},
+		}))
+	}
+	if len(opt.UpdateFields) > 0 {
+		PUT[struct{}](path+"/:id", r.update, r.apiOpts(&TypescriptOpt{
+			FunctionName: "update" + name,
+			Paths:        []string{"id"},
+			Body:         "Update" + name,
+			Response:     name,
+			Types:        []TypescriptType{{Name: "Update" + name, Model: *new(M), Fields: opt.UpdateFields, Optional: true}},
+		}))
+	}
+	DELETE[struct{}](path+"/:id", r.delete, r.apiOpts(&TypescriptOpt{
+		FunctionName: "delete" + name,
+		Paths:        []string{"id"},
+		Response:     name,
+	}))
+}
+
+/*
+checkWritable returns an error when one of the fields is not a json field of the model
+that clients may write: the primary key, gorm.DeletedAt and the auto create and update time fields are not.
+*/
+func checkWritable[M any](fieldSets ...[]string) error {
+	s, err := schema.Parse(new(M), &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		return err
+	}
+	fields := make(map[string]*schema.Field)
+	for _, field := range s.Fields {
+		if field.DBName != "" {
+			fields[jsonNameOf(field)] = field
+		}
+	}
+	for _, set := range fieldSets {
+		for _, f := range set {
+			field, ok := fields[f]
+			if !ok || f == "-" {
+				return fmt.Errorf("unknown field %s", f)
+			}
+			if field.PrimaryKey || field.FieldType == reflect.TypeOf(gorm.DeletedAt{}) ||
+				field.AutoCreateTime > 0 || field.AutoUpdateTime > 0 {
+				return fmt.Errorf("field %s cannot be written", f)
+			}
+		}
+	}
+	return nil
+}
+
+/*
+jsonNameOf returns the json name of the field, "-" when it is not marshaled.
+*/
+func jsonNameOf(field *schema.Field) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" {
+		name = field.Name
+	}
+	return name
+}
+
+type resource[M any] struct {
+	opts *ResourceOpts[M]
+
+	once    sync.Once
+	err     error
+	schema  *schema.Schema
+	columns map[string]string // json field to column
+	filters []FilterOpt       // filters with resolved columns
+}
+
+func (r *resource[M]) apiOpts(ts *TypescriptOpt) *ApiOpts {
+	opt := new(ApiOpts)
+	if r.opts.Api != nil {
+		*opt = *r.opts.Api
+	}
+	opt.Typescript = ts
+	return opt
+}
+
+/*
+prepare parses the model schema on the first request,
+as it needs the naming strategy of the connected database.
+*/
+func (r *resource[M]) prepare(db *gorm.DB) error {
+	r.once.Do(func() {
+		stmt := &gorm.Statement{DB: db}
+		if r.err = stmt.Parse(new(M)); r.err != nil {
+			return
+		}
+		r.schema = stmt.Schema
+		if r.schema.PrioritizedPrimaryField == nil {
+			r.err = fmt.Errorf("resource %s: no primary key", r.schema.Name)
+			return
+		}
+
+		r.columns = make(map[string]string)
+		for _, field := range r.schema.Fields {
+			if field.DBName == "" {
+				continue
+			}
+			if jsonName := jsonNameOf(field); jsonName != "-" {
+				r.columns[jsonName] = field.DBName
+			}
+		}
+
+		r.filters = make([]FilterOpt, 0, len(r.opts.Filters))
+		for _, f := range r.opts.Filters {
+			column, ok := r.columns[f.Field]
+			if !ok {
+				r.err = fmt.Errorf("resource %s: unknown filter %s", r.schema.Name, f.Field)
+				return
+			}
+			if f.Column == "" {
+				f.Column = column
+			}
+			r.filters = append(r.filters, f)
+		}
+	})
+	return r.err
+}
+
+/*
+query returns the scoped query of the model, responding on failure.
+*/
+func (r *resource[M]) query(c *Context[struct{}]) (*gorm.DB, bool) {
+	db := c.DB()
+	if db == nil {
+		c.InternalServerError("internal server error")
+		return nil, false
+	}
+	if err := r.prepare(db); err != nil {
+		c.LogErr(err)
+		c.InternalServerError("internal server error")
+		return nil, false
+	}
+	tx := db.Model(new(M))
+	if r.opts.Scope != nil {
+		tx = r.opts.Scope(c, tx)
+	}
+	return tx, true
+}
+
+func (r *resource[M]) authorize(c *Context[struct{}], action string, record *M) bool {
+	if r.opts.Authorize == nil {
+		return true
+	}
+	if err := r.opts.Authorize(c, action, record); err != nil {
+		c.Forbidden(err.Error())
+		return false
+	}
+	return true
+}
+
+/*
+find returns the record of the :id path parameter, responding on failure.
+*/
+func (r *resource[M]) find(c *Context[struct{}]) (*M, bool) {
+	tx, ok := r.query(c)
+	if !ok {
+		return nil, false
+	}
+	record := new(M)
+	err := tx.Where(clause.Eq{
+		Column: clause.Column{Name: r.schema.PrioritizedPrimaryField.DBName},
+		Value:  c.GinCtx.Param("id"),
+	}).First(record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.NotFound("not found")
+		return nil, false
+	}
+	if err != nil {
+		c.LogErr(err)
+		c.InternalServerError("internal server error")
+		return nil, false
+	}
+	return record, true
+}
+
+/*
+body returns the json body restricted to the allowed fields, responding on failure.
+*/
+func (r *resource[M]) body(c *Context[struct{}], allowed []string) (map[string]any, bool) {
+	body := make(map[string]any)
+	if err := c.GinCtx.ShouldBindJSON(&body); err != nil {
+		c.Err("invalid body")
+		return nil, false
+	}
+	for key := range body {
+		if !slices.Contains(allowed, key) {
+			delete(body, key)
+		}
+	}
+	return body, true
+}
+
+var errOutOfScope = errors.New("out of scope")
+
+/*
+save runs the write in a transaction, rolled back when the record is then out of the scope,
+so that a request cannot create or move records outside of it. It responds on failure.
+*/
+func (r *resource[M]) save(c *Context[struct{}], record *M, write func(tx *gorm.DB) error) bool {
+	err := c.DB().Transaction(func(tx *gorm.DB) error {
+		if err := write(tx); err != nil {
+			return err
+		}
+		if r.opts.Scope == nil {
+			return nil
+		}
+		id, _ := r.schema.PrioritizedPrimaryField.ValueOf(c.ctx, reflect.ValueOf(record).Elem())
+		var count int64
+		err := r.opts.Scope(c, tx.Model(new(M))).Where(clause.Eq{
+			Column: clause.Column{Name: r.schema.PrioritizedPrimaryField.DBName},
+			Value:  id,
+		}).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return errOutOfScope
+		}
+		return nil
+	})
+	if errors.Is(err, errOutOfScope) {
+		c.Forbidden("out of scope")
+		return false
+	}
+	if err != nil {
+		c.LogErr(err)
+		c.InternalServerError("internal server error")
+		return false
+	}
+	return true
+}
+
+func (r *resource[M]) list(c *Context[struct{}]) {
+	if !r.authorize(c, RESOURCE_ACTION_LIST, nil) {
+		return
+	}
+	tx, ok := r.query(c)
+	if !ok {
+		return
+	}
+
+	filter, err := parseFilter(c.GinCtx.Request.URL.Query(), r.filters)
+	if err != nil {
+		c.Err(err.Error())
+		return
+	}
+	tx = filter.Apply(tx)
+
+	page, err := c.Page()
+	if err != nil {
+		c.Err(err.Error())
+		return
+	}
+	// the list is always limited, even when the max page size is disabled
+	if page.Size == 0 {
+		page.Size = DEFAULT_MAX_PAGE_SIZE
+	}
+	if page.Page == 0 {
+		page.Page = 1
+	}
+	if err := tx.Session(&gorm.Session{}).Count(&page.Total).Error; err != nil {
+		c.LogErr(err)
+		c.InternalServerError("internal server error")
+		return
+	}
+	tx = tx.Offset((page.Page - 1) * page.Size).Limit(page.Size)
+
+	sort, err := c.Sort()
+	if err != nil {
+		c.Err(err.Error())
+		return
+	}
+	if sort.By != "" {
+		column, ok := r.columns[sort.By]
+		if !ok {
+			c.Err("invalid sort field")
+			return
+		}
+		tx = tx.Order(clause.OrderByColumn{Column: clause.Column{Name: column}, Desc: !sort.Asc})
+	}
+
+	records := make([]M, 0)
+	if err := tx.Find(&records).Error; err != nil {
+		c.LogErr(err)
+		c.InternalServerError("internal server error")
+		return
+	}
+	c.OK(records, page)
+}
+
+func (r *resource[M]) get(c *Context[struct{}]) {
+	record, ok := r.find(c)
+	if !ok {
+		return
+	}
+	if !r.authorize(c, RESOURCE_ACTION_GET, record) {
+		return
+	}
+	c.OK(record)
+}
+
+func (r *resource[M]) create(c *Context[struct{}]) {
+	if _, ok := r.query(c); !ok {
+		return
+	}
+	body, ok := r.body(c, r.opts.CreateFields)
+	if !ok {
+		return
+	}
+	record := new(M)
+	if err := decodeInto(body, record); err != nil {
+		c.Err("invalid body")
+		return
+	}
+	if !r.authorize(c, RESOURCE_ACTION_CREATE, record) {
+		return
+	}
+	if !r.save(c, record, func(tx *gorm.DB) error { return tx.Create(record).Error }) {
+		return
+	}
+	c.OK(record)
+}
+
+func (r *resource[M]) update(c *Context[struct{}]) {
+	record, ok := r.find(c)
+	if !ok {
+		return
+	}
+	if !r.authorize(c, RESOURCE_ACTION_UPDATE, record) {
+		return
+	}
+	body, ok := r.body(c, r.opts.UpdateFields)
+	if !ok {
+		return
+	}
+	if len(body) == 0 {
+		c.OK(record)
+		return
+	}
+	if err := decodeInto(body, record); err != nil {
+		c.Err("invalid body")
+		return
+	}
+
+	columns := make([]string, 0, len(body))
+	for key := range body {
+		columns = append(columns, r.columns[key])
+	}
+	if !r.save(c, record, func(tx *gorm.DB) error { return tx.Model(record).Select(columns).Updates(record).Error }) {
+		return
+	}
+	c.OK(record)
+}
+
+func (r *resource[M]) delete(c *Context[struct{}]) {
+	record, ok := r.find(c)
+	if !ok {
+		return
+	}
+	if !r.authorize(c, RESOURCE_ACTION_DELETE, record) {
+		return
+	}
+	tx := c.DB()
+	if r.opts.HardDelete {
+		tx = tx.Unscoped()
+	}
+	if err := tx.Delete(record).Error; err != nil {
+		c.LogErr(err)
+		c.InternalServerError("internal server error")
+		return
+	}
+	c.OK(record)
+}
+
+/*
+decodeInto sets the json fields of the body on the record.
+*/
+func decodeInto(body map[string]any, record any) error {
+	bytes, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bytes, record)
+}
@@ -1,13 +1,32 @@
 package ginger
 
-import "github.com/METADIV-GO/gorm"
+import (
+	"time"
+
+	"github.com/METADIV-GO/gorm"
+	"github.com/gin-gonic/gin"
+)
 
 type Response struct {
-	Success    bool             `json:"success"`
-	Time       string           `json:"time"`
-	TraceId    string           `json:"trace_id"`
-	Duration   int64            `json:"duration"`
-	Pagination *gorm.Pagination `json:"pagination,omitempty"`
-	ErrMessage string           `json:"err_message,omitempty"`
-	Data       any              `json:"data,omitempty"`
+	Success    bool              `json:"success"`
+	Time       string            `json:"time"`
+	TraceId    string            `json:"trace_id"`
+	Duration   int64             `json:"duration"`
+	Pagination *gorm.Pagination  `json:"pagination,omitempty"`
+	Cursor     *CursorPagination `json:"cursor,omitempty"`
+	ErrMessage string            `json:"err_message,omitempty"`
+	Data       any               `json:"data,omitempty"`
+}
+
+/*
+abortWithErr aborts the handler chain with an error response,
+for the responses written outside of the api handlers, e.g. by the rate limit.
+*/
+func abortWithErr(c *gin.Context, status int, message string) {
+	c.AbortWithStatusJSON(status, &Response{
+		Success:    false,
+		TraceId:    traceIdOf(c),
+		Time:       time.Now().Format(time.RFC3339),
+		ErrMessage: message,
+	})
 }
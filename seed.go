@@ -0,0 +1,205 @@
+package ginger
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+/*
+NewSeed registers a fixture file of the model seeded into the database.
+The file is a YAML or JSON list of records decoded with the json tags of the model,
+each with its primary key.
+When modes are given, it is only seeded when GIN_MODE is one of them.
+*/
+func NewSeed(model any, file string, modes ...string) {
+	Engine.Seeds = append(Engine.Seeds, SeedHandler{
+		Model:    model,
+		Database: "db",
+		File:     file,
+		Modes:    modes,
+	})
+}
+
+/*
+NewMemSeed registers a fixture file of the model seeded into the memory database.
+*/
+func NewMemSeed(model any, file string, modes ...string) {
+	Engine.Seeds = append(Engine.Seeds, SeedHandler{
+		Model:    model,
+		Database: "mem",
+		File:     file,
+		Modes:    modes,
+	})
+}
+
+/*
+NewDatabaseSeed registers a fixture file of the model seeded into the named database.
+*/
+func NewDatabaseSeed(name string, model any, file string, modes ...string) {
+	Engine.Seeds = append(Engine.Seeds, SeedHandler{
+		Model:    model,
+		Database: name,
+		File:     file,
+		Modes:    modes,
+	})
+}
+
+/*
+Seed loads the fixtures of the current GIN_MODE in registration order.
+The records are upserted by primary key, so seeding again is harmless.
+*/
+func (e *engine) Seed() error {
+	return e.seed("")
+}
+
+/*
+Reset drops all the tables of the memory database ("db", "mem" or a
+registered one), migrates and seeds it again.
+It is meant to isolate test cases, so only DB_TYPE_MEM databases can be reset.
+*/
+func (e *engine) Reset(name string) error {
+	db, dbType, models := e.databaseOf(name)
+	if dbType != DB_TYPE_MEM {
+		return fmt.Errorf("reset %s: only %s databases can be reset", name, DB_TYPE_MEM)
+	}
+	if db == nil {
+		return fmt.Errorf("reset %s: database is not connected", name)
+	}
+
+	tables, err := db.Migrator().GetTables()
+	if err != nil {
+		return fmt.Errorf("reset %s: %w", name, err)
+	}
+	for _, table := range tables {
+		// the internal tables of sqlite cannot be dropped
+		if strings.HasPrefix(table, "sqlite_") {
+			continue
+		}
+		if err := db.Migrator().DropTable(table); err != nil {
+			return fmt.Errorf("reset %s: drop %s: %w", name, table, err)
+		}
+	}
+
+	if err := autoMigrate(name, db, models); err != nil {
+		return err
+	}
+	if name != "mem" {
+		m, err := e.migrator(name)
+		if err != nil {
+			return err
+		}
+		if _, err := m.Up(false); err != nil {
+			return err
+		}
+	}
+	return e.seed(name)
+}
+
+/*
+seed loads the fixtures of the database, or of all databases when name is empty.
+*/
+func (e *engine) seed(name string) error {
+	mode := gin.Mode()
+	for _, s := range e.Seeds {
+		if name != "" && s.Database != name {
+			continue
+		}
+		if len(s.Modes) > 0 && !slices.Contains(s.Modes, mode) {
+			continue
+		}
+
+		db, _, _ := e.databaseOf(s.Database)
+		if db == nil {
+			return fmt.Errorf("seed %s: database %s is not connected", s.File, s.Database)
+		}
+		if err := seedFile(db, s.Model, s.File); err != nil {
+			return fmt.Errorf("seed %s: %w", s.File, err)
+		}
+	}
+	return nil
+}
+
+/*
+seedFile upserts the records of the fixture file into the table of the model.
+Every record must have its primary key, by which it is upserted.
+*/
+func seedFile(db *gorm.DB, model any, file string) error {
+	bytes, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	// YAML is converted to JSON so that both formats use the json tags of the model
+	var raw any
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(bytes, &raw); err != nil {
+			return err
+		}
+		if bytes, err = json.Marshal(raw); err != nil {
+			return err
+		}
+	case ".json":
+	default:
+		return fmt.Errorf("unsupported fixture format %s", filepath.Ext(file))
+	}
+
+	modelType := reflect.TypeOf(model)
+	for modelType.Kind() == reflect.Pointer {
+		modelType = modelType.Elem()
+	}
+	records := reflect.New(reflect.SliceOf(modelType))
+	if err := json.Unmarshal(bytes, records.Interface()); err != nil {
+		return err
+	}
+	if records.Elem().Len() == 0 {
+		return nil
+	}
+
+	// without primary keys the records would be inserted again on every start
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	if len(stmt.Schema.PrimaryFields) == 0 {
+		return fmt.Errorf("%s has no primary key", stmt.Schema.Name)
+	}
+	for i := 0; i < records.Elem().Len(); i++ {
+		record := records.Elem().Index(i)
+		for _, field := range stmt.Schema.PrimaryFields {
+			if _, zero := field.ValueOf(db.Statement.Context, record); zero {
+				return fmt.Errorf("record %d has no %s", i, field.DBName)
+			}
+		}
+	}
+
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(records.Interface()).Error
+}
+
+/*
+databaseOf returns the database, its type and its auto migrated models
+by name, "db", "mem" or a registered one.
+*/
+func (e *engine) databaseOf(name string) (*gorm.DB, string, []any) {
+	switch name {
+	case "db":
+		return e.DB, e.Configs.DBType, e.DBMigrate
+	case "mem":
+		return e.MEM, e.Configs.MEMType, e.MemMigrate
+	}
+	handler := e.databaseHandler(name)
+	if handler == nil {
+		return nil, "", nil
+	}
+	return e.Database(name), handler.Type, handler.Migrate
+}
@@ -0,0 +1,200 @@
+package ginger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+timeoutToHandler bounds the rest of the handler chain with the timeout.
+The chain runs against a buffered writer, when the deadline passes first the 504 envelope is sent
+at once and the late response is discarded. It still waits for the chain to return,
+its context being cancelled, before the gin context is released.
+*/
+func timeoutToHandler(timeout time.Duration) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceId := traceIdOf(ctx)
+		startAt := time.Now()
+		timeoutCtx, cancel := context.WithTimeout(ctx.Request.Context(), timeout)
+		defer cancel()
+		ctx.Request = ctx.Request.WithContext(timeoutCtx)
+
+		writer := ctx.Writer
+		buffer := &timeoutWriter{ResponseWriter: writer, header: writer.Header().Clone(), status: http.StatusOK}
+		ctx.Writer = buffer
+
+		done := make(chan *handlerPanic, 1)
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					done <- &handlerPanic{value: r, stack: debug.Stack()}
+					return
+				}
+				done <- nil
+			}()
+			ctx.Next()
+		}()
+
+		var p *handlerPanic
+		select {
+		case p = <-done:
+			ctx.Writer = writer
+			if p == nil {
+				buffer.flush()
+			}
+		case <-timeoutCtx.Done():
+			buffer.discard()
+			status, message := http.StatusGatewayTimeout, "request timeout"
+			if errors.Is(timeoutCtx.Err(), context.Canceled) {
+				status, message = http.StatusServiceUnavailable, "request cancelled"
+			}
+			writeEnvelope(writer, status, &Response{
+				Success:    false,
+				TraceId:    traceId,
+				Time:       time.Now().Format(time.RFC3339),
+				Duration:   time.Since(startAt).Milliseconds(),
+				ErrMessage: message,
+			})
+			p = <-done
+			ctx.Writer = writer
+		}
+		if p != nil {
+			panic(p)
+		}
+	}
+}
+
+/*
+handlerPanic is a panic of the handler chain rethrown by the timeout with its original stack.
+*/
+type handlerPanic struct {
+	value any
+	stack []byte
+}
+
+/*
+writeEnvelope writes the response envelope to the writer and flushes it.
+*/
+func writeEnvelope(w gin.ResponseWriter, status int, resp *Response) {
+	body, err := json.Marshal(resp)
+	if err != nil {
+		status, body = http.StatusInternalServerError, nil
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(body)
+	w.Flush()
+}
+
+/*
+timeoutWriter buffers the response of the chain until it returns before the deadline.
+*/
+type timeoutWriter struct {
+	gin.ResponseWriter
+
+	mu        sync.Mutex
+	header    http.Header
+	body      bytes.Buffer
+	status    int
+	written   bool
+	discarded bool
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if code > 0 && !w.written {
+		w.status = code
+	}
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.written = true
+}
+
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.written = true
+	if w.discarded {
+		return len(data), nil
+	}
+	return w.body.Write(data)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *timeoutWriter) Status() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status
+}
+
+func (w *timeoutWriter) Size() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.written {
+		return -1
+	}
+	return w.body.Len()
+}
+
+func (w *timeoutWriter) Written() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.written
+}
+
+// the response is sent at once by flush
+func (w *timeoutWriter) Flush() {}
+
+/*
+discard drops the buffered response and the later writes.
+*/
+func (w *timeoutWriter) discard() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.discarded = true
+	w.body.Reset()
+}
+
+/*
+flush sends the buffered response to the underlying writer.
+*/
+func (w *timeoutWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	dst := w.ResponseWriter.Header()
+	for k := range dst {
+		if _, ok := w.header[k]; !ok {
+			dst.Del(k)
+		}
+	}
+	for k, v := range w.header {
+		dst[k] = v
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if !w.written {
+		return
+	}
+	w.ResponseWriter.WriteHeaderNow()
+	if w.body.Len() > 0 {
+		w.ResponseWriter.Write(w.body.Bytes())
+	}
+}
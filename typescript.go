@@ -3,6 +3,7 @@ package ginger
 import (
 	"net/http"
 	"os"
+	"reflect"
 	"strings"
 	"time"
 
@@ -37,13 +38,37 @@ export interface ErrorResp {
 	code: string;
 	message: string;
 }
+export interface CursorPagination {
+	limit: number;
+	next?: string;
+	prev?: string;
+}
 export interface Response<T> {
 	success: boolean;
 	duration: number;
 	pagination?: any;
+	cursor?: CursorPagination;
 	error?: ErrorResp;
 	data?: T;
 }
+export type FilterValue = string | number | boolean | Date;
+export type FilterOperators = { [op: string]: FilterValue | FilterValue[] | undefined };
+export const filterQuery = (filter: { [field: string]: FilterOperators | undefined }): string => {
+	const query: string[] = [];
+	for (const field in filter) {
+		const ops = filter[field] || {};
+		for (const op in ops) {
+			const value = ops[op];
+			if (value === undefined) {
+				continue;
+			}
+			const values = Array.isArray(value) ? value : [value];
+			const encoded = values.map((v) => (v instanceof Date ? v.toISOString() : String(v))).join(',');
+			query.push('filter[' + field + '][' + op + ']=' + encodeURIComponent(encoded));
+		}
+	}
+	return query.join('&');
+}
 `), os.ModePerm)
 }
 
@@ -53,6 +78,7 @@ func (s *modelService) CreateModels() {
 	convertor.BackupDir = ""
 	convertor.ManageType(time.Time{}, typescriptify.TypeOptions{TSType: "Date", TSTransform: "new Date(__VALUE__)"})
 
+	types := ""
 	for i := range Engine.ApiHandlers {
 		if Engine.ApiHandlers[i].Opts == nil || Engine.ApiHandlers[i].Opts.Typescript == nil {
 			continue
@@ -61,9 +87,38 @@ func (s *modelService) CreateModels() {
 		for j := range opt.Models {
 			convertor.Add(opt.Models[j])
 		}
+		for _, t := range opt.Types {
+			convertor.Add(t.Model)
+			types += s.typeOf(t)
+		}
 	}
 
 	convertor.ConvertToFile("./apis/models.ts")
+	if types != "" {
+		if f, err := os.OpenFile("./apis/models.ts", os.O_APPEND|os.O_WRONLY, os.ModePerm); err == nil {
+			f.WriteString("\n" + types)
+			f.Close()
+		}
+	}
+}
+
+/*
+typeOf returns the declaration of the type picking the fields of its model.
+*/
+func (s *modelService) typeOf(t TypescriptType) string {
+	fields := make([]string, 0, len(t.Fields))
+	for _, f := range t.Fields {
+		fields = append(fields, "'"+f+"'")
+	}
+	model := reflect.TypeOf(t.Model)
+	for model.Kind() == reflect.Pointer {
+		model = model.Elem()
+	}
+	picked := "Pick<" + model.Name() + ", " + strings.Join(fields, " | ") + ">"
+	if t.Optional {
+		picked = "Partial<" + picked + ">"
+	}
+	return "export type " + t.Name + " = " + picked + ";\n"
 }
 
 var ApiService = new(apiService)
@@ -74,6 +129,7 @@ func (s *apiService) CreateApis() {
 
 	apiInfo := new(ApiInfo)
 	apiInfo.Imports = make(map[string]bool)
+	hasFilter := false
 	for _, api := range Engine.ApiHandlers {
 
 		if api.Handler == nil {
@@ -95,7 +151,11 @@ func (s *apiService) CreateApis() {
 			panic("typescript: function name is empty for " + api.Path)
 		}
 
-		apiContent := "export const " + opt.FunctionName + " = ("
+		apiContent := ""
+		if names := Engine.middlewareNames(api); len(names) > 0 {
+			apiContent += "/** middlewares: " + strings.Join(names, ", ") + " */\n"
+		}
+		apiContent += "export const " + opt.FunctionName + " = ("
 		if opt.Paths != nil && len(opt.Paths) > 0 {
 			for i := range opt.Paths {
 				apiContent += opt.Paths[i] + ": any, "
@@ -109,6 +169,10 @@ func (s *apiService) CreateApis() {
 		if opt.Body != "" {
 			apiContent += "req: " + opt.Body + ", "
 		}
+		if len(api.Opts.Filters) > 0 {
+			hasFilter = true
+			apiContent += "filter: " + s.filterType(api.Opts.Filters) + " = {}, "
+		}
 		apiContent = strings.TrimSuffix(apiContent, ", ")
 		var resp string
 		if opt.Response != "" {
@@ -149,6 +213,14 @@ func (s *apiService) CreateApis() {
 			}
 		}
 		query = strings.TrimSuffix(query, "&")
+		if len(api.Opts.Filters) > 0 {
+			if query == "" {
+				query = "?"
+			} else {
+				query += "&"
+			}
+			query += "${filterQuery(filter)}"
+		}
 
 		apiContent += "\treturn axios." + method + "(`" + url + query + "`"
 		if opt.Body != "" {
@@ -162,7 +234,11 @@ func (s *apiService) CreateApis() {
 	}
 
 	page := "import axios, { AxiosResponse } from 'axios';\n"
-	page += "import { Response } from './general';\n"
+	if hasFilter {
+		page += "import { Response, FilterValue, filterQuery } from './general';\n"
+	} else {
+		page += "import { Response } from './general';\n"
+	}
 	for model := range apiInfo.Imports {
 		page += "import { " + model + " } from './models';\n"
 	}
@@ -170,3 +246,26 @@ func (s *apiService) CreateApis() {
 	page += apiInfo.Content
 	os.WriteFile("./apis/api.ts", []byte(page), os.ModePerm)
 }
+
+/*
+filterType returns the typescript type of the allowed filters,
+e.g. { status?: { eq?: FilterValue; in?: FilterValue[] } }.
+*/
+func (s *apiService) filterType(filters []FilterOpt) string {
+	fields := make([]string, 0, len(filters))
+	for _, f := range filters {
+		operators := make([]string, 0)
+		for _, op := range f.operators() {
+			switch op {
+			case FILTER_OP_IN:
+				operators = append(operators, op+"?: FilterValue[]")
+			case FILTER_OP_NULL:
+				operators = append(operators, op+"?: boolean")
+			default:
+				operators = append(operators, op+"?: FilterValue")
+			}
+		}
+		fields = append(fields, "'"+f.Field+"'?: { "+strings.Join(operators, "; ")+" }")
+	}
+	return "{ " + strings.Join(fields, "; ") + " }"
+}